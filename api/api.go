@@ -1,22 +1,40 @@
 package api
 
 import (
+	"crypto/subtle"
 	"net"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/river"
 	"github.com/go-zoo/bone"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/oauth2.v3/server"
 )
 
 // TimeTracker defines time tracker interface.
+//
+// Deprecated: use the river_bulk_duration_seconds metric.
 type TimeTracker interface {
 	Avg() time.Duration
 	ThirdsDiff() int
 	GetDurations() []time.Duration
 }
 
+// River defines the interface of the running river.
+type River interface {
+	DeadLetters() ([]*river.DeadLetter, error)
+	ReplayDeadLetters(ids []string) (int, error)
+	Lag() (*river.Lag, error)
+	Reload() error
+	Backfill(schema, table string) error
+	Backfills() []*river.BackfillStatus
+	Reindex(index string, deleteOld bool) error
+	Reindexes() []*river.ReindexStatus
+}
+
 // API contains HTTP server's settings.
 type API struct {
 	port      int
@@ -27,6 +45,9 @@ type API struct {
 	authPass  string
 	authToken string
 	tt        TimeTracker
+
+	riverLock sync.RWMutex
+	river     River
 }
 
 // New returns new API.
@@ -41,10 +62,56 @@ func (a *API) defineMux() error {
 	a.mux = bone.New()
 
 	a.mux.Get("/timetracker", http.HandlerFunc(a.statsHandler))
+	a.mux.Get("/metrics", promhttp.Handler())
+	a.mux.Get("/lag", http.HandlerFunc(a.lagHandler))
+	a.mux.Get("/deadletters", http.HandlerFunc(a.deadLettersHandler))
+	a.mux.Post("/deadletters/replay", a.authorized(a.replayDeadLettersHandler))
+	a.mux.Post("/reload", a.authorized(a.reloadHandler))
+	a.mux.Get("/backfill", http.HandlerFunc(a.backfillsHandler))
+	a.mux.Post("/backfill", a.authorized(a.backfillHandler))
+	a.mux.Get("/reindex", http.HandlerFunc(a.reindexesHandler))
+	a.mux.Post("/reindex", a.authorized(a.reindexHandler))
 
 	return nil
 }
 
+// SetRiver sets the river served by the river endpoints, they respond
+// with 503 until it is set.
+func (a *API) SetRiver(r River) {
+	a.riverLock.Lock()
+	a.river = r
+	a.riverLock.Unlock()
+}
+
+func (a *API) getRiver() River {
+	a.riverLock.RLock()
+	defer a.riverLock.RUnlock()
+
+	return a.river
+}
+
+// SetAuthToken sets the bearer token required by the endpoints changing the
+// river, they are disabled if no token is set.
+func (a *API) SetAuthToken(token string) {
+	a.authToken = token
+}
+
+func (a *API) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(a.authToken) == 0 {
+			respond(errorResp{"API token is not configured"}, http.StatusForbidden, w)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+a.authToken)) != 1 {
+			respond(errorResp{"unauthorized"}, http.StatusUnauthorized, w)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
 // Start starts the HTTP server.
 func (a *API) Start() (err error) {
 	err = a.defineMux()
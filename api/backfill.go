@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/river"
+	"github.com/juju/errors"
+)
+
+type backfillsResp struct {
+	Backfills []*river.BackfillStatus `json:"backfills"`
+}
+
+type backfillReq struct {
+	Schema string `json:"schema"`
+	Table  string `json:"table"`
+}
+
+type backfillResp struct {
+	Started bool `json:"started"`
+}
+
+func (a *API) backfillsHandler(w http.ResponseWriter, r *http.Request) {
+	rv := a.getRiver()
+	if rv == nil {
+		respond(errorResp{"river is not running"}, http.StatusServiceUnavailable, w)
+		return
+	}
+
+	respond(backfillsResp{Backfills: rv.Backfills()}, http.StatusOK, w)
+}
+
+// backfillHandler starts the backfill of the table in the request body,
+// its progress is reported by backfillsHandler.
+func (a *API) backfillHandler(w http.ResponseWriter, r *http.Request) {
+	rv := a.getRiver()
+	if rv == nil {
+		respond(errorResp{"river is not running"}, http.StatusServiceUnavailable, w)
+		return
+	}
+
+	var req backfillReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond(errorResp{err.Error()}, http.StatusBadRequest, w)
+		return
+	}
+
+	if len(req.Schema) == 0 || len(req.Table) == 0 {
+		respond(errorResp{"schema and table are required"}, http.StatusBadRequest, w)
+		return
+	}
+
+	err := rv.Backfill(req.Schema, req.Table)
+	switch {
+	case err == nil:
+		respond(backfillResp{Started: true}, http.StatusAccepted, w)
+	case errors.Cause(err) == river.ErrRuleNotExist:
+		respond(errorResp{err.Error()}, http.StatusNotFound, w)
+	default:
+		respond(errorResp{err.Error()}, http.StatusConflict, w)
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gavv/httpexpect"
+)
+
+func TestBackfill(t *testing.T) {
+	// Define test API.
+	testAPI := New(0, nil)
+	testAPI.defineMux()
+
+	testServer := httptest.NewServer(testAPI.mux)
+	defer testServer.Close()
+
+	e := httpexpect.WithConfig(httpexpect.Config{
+		BaseURL:  testServer.URL,
+		Reporter: httpexpect.NewRequireReporter(t),
+	})
+
+	q := &testRiver{}
+	testAPI.SetRiver(q)
+	testAPI.SetAuthToken("secret")
+
+	// Start without token.
+	e.Request(http.MethodPost, "/backfill").
+		WithJSON(map[string]interface{}{"schema": "test", "table": "t"}).
+		Expect().
+		Status(http.StatusUnauthorized)
+
+	e.Request(http.MethodPost, "/backfill").
+		WithHeader("Authorization", "Bearer secret").
+		WithJSON(map[string]interface{}{"schema": "test"}).
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.Request(http.MethodPost, "/backfill").
+		WithHeader("Authorization", "Bearer secret").
+		WithJSON(map[string]interface{}{"schema": "other", "table": "t"}).
+		Expect().
+		Status(http.StatusNotFound)
+
+	e.Request(http.MethodPost, "/backfill").
+		WithHeader("Authorization", "Bearer secret").
+		WithJSON(map[string]interface{}{"schema": "test", "table": "t"}).
+		Expect().
+		Status(http.StatusAccepted).
+		JSON().
+		Equal(map[string]interface{}{"started": true})
+
+	// Already running.
+	e.Request(http.MethodPost, "/backfill").
+		WithHeader("Authorization", "Bearer secret").
+		WithJSON(map[string]interface{}{"schema": "test", "table": "t"}).
+		Expect().
+		Status(http.StatusConflict)
+
+	sts := e.Request(http.MethodGet, "/backfill").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("backfills").Array()
+
+	sts.Length().Equal(1)
+	sts.Element(0).Object().ValueEqual("rule", "test:t")
+	sts.Element(0).Object().ValueEqual("running", true)
+}
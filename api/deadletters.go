@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/river"
+)
+
+type deadLettersResp struct {
+	DeadLetters []*river.DeadLetter `json:"deadLetters"`
+}
+
+type replayDeadLettersReq struct {
+	IDs []string `json:"ids"`
+}
+
+type replayDeadLettersResp struct {
+	Replayed int `json:"replayed"`
+}
+
+func (a *API) deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	rv := a.getRiver()
+	if rv == nil {
+		respond(errorResp{"river is not running"}, http.StatusServiceUnavailable, w)
+		return
+	}
+
+	dls, err := rv.DeadLetters()
+	if err != nil {
+		respond(errorResp{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	if dls == nil {
+		dls = []*river.DeadLetter{}
+	}
+
+	respond(deadLettersResp{DeadLetters: dls}, http.StatusOK, w)
+}
+
+// replayDeadLettersHandler replays the dead letters listed in the request body,
+// all of them if the body is empty.
+func (a *API) replayDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	rv := a.getRiver()
+	if rv == nil {
+		respond(errorResp{"river is not running"}, http.StatusServiceUnavailable, w)
+		return
+	}
+
+	var req replayDeadLettersReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respond(errorResp{err.Error()}, http.StatusBadRequest, w)
+		return
+	}
+
+	n, err := rv.ReplayDeadLetters(req.IDs)
+	if err != nil {
+		respond(errorResp{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	respond(replayDeadLettersResp{Replayed: n}, http.StatusOK, w)
+}
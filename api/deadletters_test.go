@@ -0,0 +1,152 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/river"
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/gavv/httpexpect"
+)
+
+type testRiver struct {
+	dls      []*river.DeadLetter
+	replayed []string
+	reloaded bool
+	backfill []string
+	reindex  []string
+}
+
+func (q *testRiver) DeadLetters() ([]*river.DeadLetter, error) {
+	return q.dls, nil
+}
+
+func (q *testRiver) ReplayDeadLetters(ids []string) (int, error) {
+	q.replayed = ids
+	if len(ids) == 0 {
+		return len(q.dls), nil
+	}
+
+	return len(ids), nil
+}
+
+func (q *testRiver) Reload() error {
+	q.reloaded = true
+	return nil
+}
+
+func (q *testRiver) Backfill(schema, table string) error {
+	if schema != "test" {
+		return river.ErrRuleNotExist
+	}
+
+	for _, t := range q.backfill {
+		if t == table {
+			return river.ErrBackfillRunning
+		}
+	}
+
+	q.backfill = append(q.backfill, table)
+	return nil
+}
+
+func (q *testRiver) Backfills() []*river.BackfillStatus {
+	sts := make([]*river.BackfillStatus, 0, len(q.backfill))
+	for _, t := range q.backfill {
+		sts = append(sts, &river.BackfillStatus{Rule: "test:" + t, Running: true})
+	}
+
+	return sts
+}
+
+func (q *testRiver) Reindex(index string, deleteOld bool) error {
+	if index != "t" {
+		return river.ErrRuleNotExist
+	}
+
+	for _, i := range q.reindex {
+		if i == index {
+			return river.ErrReindexRunning
+		}
+	}
+
+	q.reindex = append(q.reindex, index)
+	return nil
+}
+
+func (q *testRiver) Reindexes() []*river.ReindexStatus {
+	sts := make([]*river.ReindexStatus, 0, len(q.reindex))
+	for _, i := range q.reindex {
+		sts = append(sts, &river.ReindexStatus{Alias: i, Index: i + "_20190607100910", Running: true})
+	}
+
+	return sts
+}
+
+func (q *testRiver) Lag() (*river.Lag, error) {
+	return &river.Lag{Bytes: 1024, Seconds: 2.5}, nil
+}
+
+func TestDeadLetters(t *testing.T) {
+	// Define test API.
+	testAPI := New(0, nil)
+	testAPI.defineMux()
+
+	testServer := httptest.NewServer(testAPI.mux)
+	defer testServer.Close()
+
+	e := httpexpect.WithConfig(httpexpect.Config{
+		BaseURL:  testServer.URL,
+		Reporter: httpexpect.NewRequireReporter(t),
+	})
+
+	// No river yet.
+	e.Request(http.MethodGet, "/deadletters").
+		Expect().
+		Status(http.StatusServiceUnavailable)
+
+	q := &testRiver{
+		dls: []*river.DeadLetter{
+			{ID: "1-1", Rule: "test:t", Status: 400, Request: &sink.Event{Action: sink.ActionIndex, Index: "t", ID: "1"}},
+			{ID: "1-2", Rule: "test:t", Status: 400, Request: &sink.Event{Action: sink.ActionIndex, Index: "t", ID: "2"}},
+		},
+	}
+	testAPI.SetRiver(q)
+	testAPI.SetAuthToken("secret")
+
+	dls := e.Request(http.MethodGet, "/deadletters").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("deadLetters").Array()
+
+	dls.Length().Equal(2)
+	dls.Element(0).Object().ValueEqual("id", "1-1")
+	dls.Element(1).Object().Value("request").Object().ValueEqual("id", "2")
+
+	// Replay without token.
+	e.Request(http.MethodPost, "/deadletters/replay").
+		Expect().
+		Status(http.StatusUnauthorized)
+
+	// Replay all.
+	e.Request(http.MethodPost, "/deadletters/replay").
+		WithHeader("Authorization", "Bearer secret").
+		Expect().
+		Status(http.StatusOK).
+		JSON().
+		Equal(map[string]interface{}{"replayed": 2})
+
+	// Replay selected.
+	e.Request(http.MethodPost, "/deadletters/replay").
+		WithHeader("Authorization", "Bearer secret").
+		WithJSON(map[string]interface{}{"ids": []string{"1-2"}}).
+		Expect().
+		Status(http.StatusOK).
+		JSON().
+		Equal(map[string]interface{}{"replayed": 1})
+
+	if len(q.replayed) != 1 || q.replayed[0] != "1-2" {
+		t.Errorf("Expected: replayed [1-2], but: was %v", q.replayed)
+	}
+}
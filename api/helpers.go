@@ -6,6 +6,10 @@ import (
 	"net/http"
 )
 
+type errorResp struct {
+	Error string `json:"error"`
+}
+
 func respond(data interface{}, statusCode int, w http.ResponseWriter) {
 	// Return JSON type.
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"net/http"
+)
+
+func (a *API) lagHandler(w http.ResponseWriter, r *http.Request) {
+	rv := a.getRiver()
+	if rv == nil {
+		respond(errorResp{"river is not running"}, http.StatusServiceUnavailable, w)
+		return
+	}
+
+	lag, err := rv.Lag()
+	if err != nil {
+		respond(errorResp{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	respond(lag, http.StatusOK, w)
+}
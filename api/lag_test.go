@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gavv/httpexpect"
+)
+
+func TestGetLag(t *testing.T) {
+	// Define test API.
+	testAPI := New(0, nil)
+	testAPI.defineMux()
+
+	testServer := httptest.NewServer(testAPI.mux)
+	defer testServer.Close()
+
+	e := httpexpect.WithConfig(httpexpect.Config{
+		BaseURL:  testServer.URL,
+		Reporter: httpexpect.NewRequireReporter(t),
+	})
+
+	e.Request(http.MethodGet, "/lag").
+		Expect().
+		Status(http.StatusServiceUnavailable)
+
+	testAPI.SetRiver(&testRiver{})
+
+	e.Request(http.MethodGet, "/lag").
+		Expect().
+		Status(http.StatusOK).
+		JSON().
+		Equal(map[string]interface{}{
+			"bytes":   1024,
+			"seconds": 2.5,
+		})
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/river"
+	"github.com/juju/errors"
+)
+
+type reindexesResp struct {
+	Reindexes []*river.ReindexStatus `json:"reindexes"`
+}
+
+type reindexReq struct {
+	Index     string `json:"index"`
+	DeleteOld bool   `json:"delete_old"`
+}
+
+type reindexResp struct {
+	Started bool `json:"started"`
+}
+
+func (a *API) reindexesHandler(w http.ResponseWriter, r *http.Request) {
+	rv := a.getRiver()
+	if rv == nil {
+		respond(errorResp{"river is not running"}, http.StatusServiceUnavailable, w)
+		return
+	}
+
+	respond(reindexesResp{Reindexes: rv.Reindexes()}, http.StatusOK, w)
+}
+
+// reindexHandler starts the rebuild of the index in the request body, its
+// progress is reported by reindexesHandler.
+func (a *API) reindexHandler(w http.ResponseWriter, r *http.Request) {
+	rv := a.getRiver()
+	if rv == nil {
+		respond(errorResp{"river is not running"}, http.StatusServiceUnavailable, w)
+		return
+	}
+
+	var req reindexReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respond(errorResp{err.Error()}, http.StatusBadRequest, w)
+		return
+	}
+
+	if len(req.Index) == 0 {
+		respond(errorResp{"index is required"}, http.StatusBadRequest, w)
+		return
+	}
+
+	err := rv.Reindex(req.Index, req.DeleteOld)
+	switch {
+	case err == nil:
+		respond(reindexResp{Started: true}, http.StatusAccepted, w)
+	case errors.Cause(err) == river.ErrRuleNotExist:
+		respond(errorResp{err.Error()}, http.StatusNotFound, w)
+	default:
+		respond(errorResp{err.Error()}, http.StatusConflict, w)
+	}
+}
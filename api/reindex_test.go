@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gavv/httpexpect"
+)
+
+func TestReindex(t *testing.T) {
+	// Define test API.
+	testAPI := New(0, nil)
+	testAPI.defineMux()
+
+	testServer := httptest.NewServer(testAPI.mux)
+	defer testServer.Close()
+
+	e := httpexpect.WithConfig(httpexpect.Config{
+		BaseURL:  testServer.URL,
+		Reporter: httpexpect.NewRequireReporter(t),
+	})
+
+	q := &testRiver{}
+	testAPI.SetRiver(q)
+	testAPI.SetAuthToken("secret")
+
+	// Start without token.
+	e.Request(http.MethodPost, "/reindex").
+		WithJSON(map[string]interface{}{"index": "t"}).
+		Expect().
+		Status(http.StatusUnauthorized)
+
+	e.Request(http.MethodPost, "/reindex").
+		WithHeader("Authorization", "Bearer secret").
+		WithJSON(map[string]interface{}{"delete_old": true}).
+		Expect().
+		Status(http.StatusBadRequest)
+
+	e.Request(http.MethodPost, "/reindex").
+		WithHeader("Authorization", "Bearer secret").
+		WithJSON(map[string]interface{}{"index": "other"}).
+		Expect().
+		Status(http.StatusNotFound)
+
+	e.Request(http.MethodPost, "/reindex").
+		WithHeader("Authorization", "Bearer secret").
+		WithJSON(map[string]interface{}{"index": "t", "delete_old": true}).
+		Expect().
+		Status(http.StatusAccepted).
+		JSON().
+		Equal(map[string]interface{}{"started": true})
+
+	// Already running.
+	e.Request(http.MethodPost, "/reindex").
+		WithHeader("Authorization", "Bearer secret").
+		WithJSON(map[string]interface{}{"index": "t"}).
+		Expect().
+		Status(http.StatusConflict)
+
+	sts := e.Request(http.MethodGet, "/reindex").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("reindexes").Array()
+
+	sts.Length().Equal(1)
+	sts.Element(0).Object().ValueEqual("alias", "t")
+	sts.Element(0).Object().ValueEqual("running", true)
+}
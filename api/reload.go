@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+)
+
+type reloadResp struct {
+	Reloaded bool `json:"reloaded"`
+}
+
+func (a *API) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	rv := a.getRiver()
+	if rv == nil {
+		respond(errorResp{"river is not running"}, http.StatusServiceUnavailable, w)
+		return
+	}
+
+	if err := rv.Reload(); err != nil {
+		respond(errorResp{err.Error()}, http.StatusInternalServerError, w)
+		return
+	}
+
+	respond(reloadResp{Reloaded: true}, http.StatusOK, w)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gavv/httpexpect"
+)
+
+func TestReload(t *testing.T) {
+	// Define test API.
+	testAPI := New(0, nil)
+	testAPI.defineMux()
+
+	testServer := httptest.NewServer(testAPI.mux)
+	defer testServer.Close()
+
+	e := httpexpect.WithConfig(httpexpect.Config{
+		BaseURL:  testServer.URL,
+		Reporter: httpexpect.NewRequireReporter(t),
+	})
+
+	q := &testRiver{}
+	testAPI.SetRiver(q)
+
+	// No token configured.
+	e.Request(http.MethodPost, "/reload").
+		WithHeader("Authorization", "Bearer ").
+		Expect().
+		Status(http.StatusForbidden)
+
+	testAPI.SetAuthToken("secret")
+
+	e.Request(http.MethodPost, "/reload").
+		WithHeader("Authorization", "Bearer wrong").
+		Expect().
+		Status(http.StatusUnauthorized)
+
+	if q.reloaded {
+		t.Fatal("Expected: not reloaded without a valid token")
+	}
+
+	e.Request(http.MethodPost, "/reload").
+		WithHeader("Authorization", "Bearer secret").
+		Expect().
+		Status(http.StatusOK).
+		JSON().
+		Equal(map[string]interface{}{"reloaded": true})
+
+	if !q.reloaded {
+		t.Error("Expected: reloaded")
+	}
+}
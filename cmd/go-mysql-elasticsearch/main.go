@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/alex-ant/envs"
 	"github.com/fasttrack-solutions/go-mysql-elasticsearch/api"
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/elastic"
 	"github.com/fasttrack-solutions/go-mysql-elasticsearch/river"
 	ttracker "github.com/fasttrack-solutions/go-mysql-elasticsearch/timeTracker"
 	"github.com/fasttrack-solutions/go-mysql-elasticsearch/verificator"
@@ -18,14 +25,22 @@ import (
 	"github.com/siddontang/go-log/log"
 )
 
+// hashSaltEnv is the environment variable overriding hash_salt of the config
+// file.
+const hashSaltEnv = "HASHSALT"
+
 var (
-	apiPort = flag.Int("api-port", 3000, "HTTP API port number")
+	apiPort  = flag.Int("api-port", 3000, "HTTP API port number")
+	apiToken = flag.String("api-token", "", "Bearer token for the HTTP API endpoints changing the river, disabled if empty")
 
 	configFile = flag.String("config", "./etc/river.toml", "go-mysql-elasticsearch config file")
 
 	dataStorage = flag.String("dataStorage", "redis", "Data storage (redis/fs)")
 	mappingsDir = flag.String("mappingsDir", "", "Mappings directory")
 
+	allowIncompatibleMappings = flag.Bool("allowIncompatibleMappings", false, "Start even if a changed mapping file can not be applied to the existing index")
+	mappingCheck              = flag.String("mappingCheck", "warn", "Check of the rules against the existing index mappings on start (off/warn/fail)")
+
 	myAddr    = flag.String("myAddr", "127.0.0.1:3306", "MySQL addr")
 	myUser    = flag.String("myUser", "root", "MySQL user")
 	myPass    = flag.String("myPass", "root", "MySQL password")
@@ -41,30 +56,43 @@ var (
 	redisKeyPostfixAllowedToRun = flag.String("rediskey-postfix-allowed-to-run", "", "Redis key postfix for allowed to run")
 	unSyncedThreshold           = flag.Int("unsynced-threshold", 1000, "Amount of allowed unsynced binlog bytes during n threshold seconds")
 	secondsThreshold            = flag.Int("unsynced-threshold-seconds", 30, "Amount of seconds during which to check unsynced-threshold")
+	lagSecondsThreshold         = flag.Int("unsynced-threshold-lag-seconds", 0, "Allowed replication lag in seconds, 0 to check unsynced-threshold bytes only")
 
 	esAddr  = flag.String("esAddr", "127.0.0.1:9200", "Elasticsearch addr")
 	esUser  = flag.String("esUser", "", "Elasticsearch user")
 	esPass  = flag.String("esPass", "", "Elasticsearch password")
 	esHTTPS = flag.Bool("esHTTPS", false, "Use HTTPS for ES")
 
+	esAliases = flag.Bool("esAliases", false, "Create the indices of the mappings directory as versioned indices behind aliases")
+
 	dataDir        = flag.String("dataDir", "./go-mysql-elasticsearch-data", "Path for go-mysql-elasticsearch to save data")
 	statAddr       = flag.String("statAddr", "127.0.0.1:12800", "Inner HTTP status address")
 	serverID       = flag.Int("serverID", 1001, "MySQL server ID, as a pseudo slave")
 	flavor         = flag.String("flavor", "mysql", "Flavor: mysql or mariadb")
+	useGTID        = flag.Bool("useGTID", false, "Track and resume from the executed GTID set instead of binlog file and position")
 	bulkSize       = flag.Int("bulkSize", 256, "Minimal number of items to be inserted in a single bulk")
 	execution      = flag.String("exec", "mysqldump", "mysqldump execution path")
 	skipMasterData = flag.Bool("skipMasterData", false, "if no privilege to use mysqldump with --master-data, we must skip it")
 	logLevel       = flag.String("logLevel", "Info", "log level")
 
-	flushBulkTime = flag.Duration("flushBulkTime", time.Millisecond*200, "Force flush the pending requests if we don't have enough items >= bulkSize")
-	skipNoPkTable = flag.Bool("skipNoPkTable", false, "Ignore table without primary key")
+	flushBulkTime       = flag.Duration("flushBulkTime", time.Millisecond*200, "Force flush the pending requests if we don't have enough items >= bulkSize")
+	bulkRetries         = flag.Int("bulkRetries", 5, "How many times to retry bulk items failed with a transport error or 429/5xx status")
+	bulkRetryBackoff    = flag.Duration("bulkRetryBackoff", time.Millisecond*100, "Initial backoff before retrying failed bulk items, doubled on every retry")
+	bulkRetryMaxBackoff = flag.Duration("bulkRetryMaxBackoff", time.Second*10, "Maximum backoff before retrying failed bulk items")
+	skipNoPkTable       = flag.Bool("skipNoPkTable", false, "Ignore table without primary key")
+	backfill            = flag.String("backfill", "", "Comma separated schema.table list to backfill once the river is running")
+	backfillChunkSize   = flag.Int("backfillChunkSize", 1000, "Number of rows read at once by backfills and checks")
+	backfillNewTables   = flag.Bool("backfillNewTables", false, "Backfill the tables added on config reload")
+	checkInterval       = flag.Duration("checkInterval", 0, "Interval of the periodic check of ES documents against MySQL, disabled if 0")
+	checkSampleChunks   = flag.Int("checkSampleChunks", 10, "Number of random chunks checked per rule by the periodic check, the whole table if 0")
+	checkRepair         = flag.Bool("checkRepair", false, "Repair the inconsistent ES documents found by the periodic check")
 
 	brandID          = flag.Int("brand-id", 0, "Brand ID")
 	useSingleRedisDB = flag.Bool("use-single-redis-db", false, "Use single Redis DB (0), dismiss brand ID in keys if different DBs")
 	slackWebhookURL  = flag.String("slack-webhook-url", "", "Use for sending alerts to slack")
 	slackChannelName = flag.String("slack-channel-name", "", "Channel to send messages in")
 
-	bulksToTrack = flag.Int("bulks-to-track", 100, "Bulk requests to keep in time tracker")
+	bulksToTrack = flag.Int("bulks-to-track", 100, "Bulk requests to keep in time tracker, deprecated")
 )
 
 func main() {
@@ -83,19 +111,30 @@ func main() {
 	signal.Notify(sc,
 		os.Kill,
 		os.Interrupt,
-		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT)
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
 	cfg, err := river.NewConfigWithFile(*configFile)
 	if err != nil {
 		println(errors.ErrorStack(err))
 		return
 	}
 
+	// The stdout sink writes the events to stdout, the logs go to stderr.
+	if cfg.HasStdoutSink() {
+		h, _ := log.NewStreamHandler(os.Stderr)
+		log.SetDefaultLogger(log.NewDefault(h))
+		log.SetLevelByName(*logLevel)
+	}
+
 	cfg.DataStorage = *dataStorage
 	cfg.MappingsDir = *mappingsDir
+	cfg.AllowIncompatibleMappings = *allowIncompatibleMappings
+	cfg.MappingCheck = *mappingCheck
 	cfg.MyAddr = *myAddr
 	cfg.MyUser = *myUser
 	cfg.MyPassword = *myPass
@@ -107,15 +146,29 @@ func main() {
 	cfg.ESUser = *esUser
 	cfg.ESPassword = *esPass
 	cfg.ESHttps = *esHTTPS
+	cfg.ESAliases = *esAliases
 	cfg.DataDir = *dataDir
 	cfg.StatAddr = *statAddr
 	cfg.ServerID = uint32(*serverID)
 	cfg.Flavor = *flavor
+	cfg.UseGTID = *useGTID
 	cfg.BulkSize = *bulkSize
 	cfg.DumpExec = *execution
 	cfg.SkipMasterData = *skipMasterData
 	cfg.FlushBulkTime = *flushBulkTime
+	cfg.BulkRetries = *bulkRetries
+	cfg.BulkRetryBackoff = *bulkRetryBackoff
+	cfg.BulkRetryMaxBackoff = *bulkRetryMaxBackoff
 	cfg.SkipNoPkTable = *skipNoPkTable
+	cfg.BackfillChunkSize = *backfillChunkSize
+	cfg.BackfillNewTables = *backfillNewTables
+	cfg.CheckInterval = *checkInterval
+	cfg.CheckSampleChunks = *checkSampleChunks
+	cfg.CheckRepair = *checkRepair
+	// The salt is no flag, the command line can be read by other users.
+	if salt := os.Getenv(hashSaltEnv); len(salt) > 0 {
+		cfg.HashSalt = salt
+	}
 
 	ttInstance := ttracker.New(*bulksToTrack)
 
@@ -130,6 +183,10 @@ func main() {
 		*redisKeyPostfixAllowedToRun += fmt.Sprintf(":%d", *brandID)
 	}
 
+	if flag.Arg(0) == "reindex" {
+		os.Exit(runReindex(flag.Args()[1:]))
+	}
+
 	// Reconnect to MySQL.
 	log.Infof("Connecting to MySQL [%s]", cfg.MyAddr)
 	for {
@@ -143,8 +200,17 @@ func main() {
 		break
 	}
 
+	if flag.Arg(0) == "check" {
+		os.Exit(runCheck(cfg, flag.Args()[1:]))
+	}
+
+	if flag.Arg(0) == "mappings" {
+		os.Exit(runMappings(cfg, flag.Args()[1:]))
+	}
+
 	// Initialize API HTTP server.
 	apiServer := api.New(*apiPort, ttInstance)
+	apiServer.SetAuthToken(*apiToken)
 
 	// Start API HTTP server.
 	apiStartErr := apiServer.Start()
@@ -159,12 +225,36 @@ func main() {
 		return
 	}
 
+	apiServer.SetRiver(r)
+
 	done := make(chan struct{}, 1)
 	go func() {
 		r.Run()
 		done <- struct{}{}
 	}()
 
+	if len(*backfill) > 0 {
+		go func() {
+			select {
+			case <-r.WaitDumpDone():
+			case <-r.Ctx().Done():
+				return
+			}
+
+			for _, t := range strings.Split(*backfill, ",") {
+				parts := strings.SplitN(strings.TrimSpace(t), ".", 2)
+				if len(parts) != 2 {
+					log.Errorf("invalid backfill table %s, must be schema.table", t)
+					continue
+				}
+
+				if err := r.Backfill(parts[0], parts[1]); err != nil {
+					log.Errorf("backfill %s err %v", t, err)
+				}
+			}
+		}()
+	}
+
 	verificatorErrorChan := make(chan error)
 	verificatorConfig := verificator.Config{
 		River:                       r,
@@ -177,11 +267,9 @@ func main() {
 		RedisKeyPostfixSuicideCount: *redisKeyPostfixSuicideCount,
 		RedisKeyPostfixAllowedToRun: *redisKeyPostfixAllowedToRun,
 		RedisDB:                     *redisDB,
-		MyUser:                      *myUser,
-		MyPass:                      *myPass,
-		MyAddr:                      *myAddr,
 		SecondsThreshold:            *secondsThreshold,
 		UnSyncedThreshold:           *unSyncedThreshold,
+		LagSecondsThreshold:         *lagSecondsThreshold,
 		ErrorChan:                   verificatorErrorChan,
 	}
 	v, err := verificator.InitAndStart(verificatorConfig)
@@ -189,17 +277,224 @@ func main() {
 		log.Fatal("Could not start verificator! Err: ", err)
 	}
 
-	select {
-	case n := <-sc:
-		log.Infof("receive signal %v, closing", n)
-		v.Shutdown()
-		os.Exit(0)
-	case <-r.Ctx().Done():
-		log.Infof("context is done with %v, closing", r.Ctx().Err())
-	case err := <-verificatorErrorChan:
-		fmt.Println("Verificator error: ", err)
+loop:
+	for {
+		select {
+		case <-hup:
+			log.Infof("receive SIGHUP, reloading config %s", *configFile)
+			if err := r.Reload(); err != nil {
+				log.Errorf("reload config err %v", err)
+			}
+		case n := <-sc:
+			log.Infof("receive signal %v, closing", n)
+			v.Shutdown()
+			os.Exit(0)
+		case <-r.Ctx().Done():
+			log.Infof("context is done with %v, closing", r.Ctx().Err())
+			break loop
+		case err := <-verificatorErrorChan:
+			log.Errorf("verificator err %v", err)
+			break loop
+		}
 	}
 
 	r.Close()
 	<-done
 }
+
+// runCheck runs the check subcommand and returns the exit code, 1 if
+// inconsistent documents are found and not repaired.
+func runCheck(cfg *river.Config, args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	sampleChunks := fs.Int("sample", 0, "Number of random chunks checked per rule, the whole table if 0")
+	repair := fs.Bool("repair", false, "Repair the inconsistent documents")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] check [-sample n] [-repair] [schema.table ...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	// Another river may serve the status address, the checker does not sync.
+	cfg.StatAddr = ""
+
+	r, err := river.NewRiver(cfg)
+	if err != nil {
+		println(errors.ErrorStack(err))
+		return 1
+	}
+	defer r.Close()
+
+	reports, err := r.Check(river.CheckOptions{
+		Tables:       fs.Args(),
+		SampleChunks: *sampleChunks,
+		Repair:       *repair,
+	})
+	if err != nil {
+		println(errors.ErrorStack(err))
+		return 1
+	}
+
+	code := 0
+	for _, report := range reports {
+		if !report.Consistent() && !*repair {
+			code = 1
+		}
+	}
+
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	if err = e.Encode(reports); err != nil {
+		log.Errorf("write check reports err %v", err)
+		return 1
+	}
+
+	return code
+}
+
+// runMappings runs the mappings subcommand writing the mapping files generated
+// from the table schemas and returns the exit code.
+func runMappings(cfg *river.Config, args []string) int {
+	fs := flag.NewFlagSet("mappings", flag.ExitOnError)
+	out := fs.String("out", cfg.MappingsDir, "Directory the mapping files are written to, the mappings directory by default")
+	force := fs.Bool("force", false, "Overwrite the existing mapping files")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] mappings [-out dir] [-force]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if len(*out) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ms, err := river.GenerateMappings(cfg)
+	if err != nil {
+		println(errors.ErrorStack(err))
+		return 1
+	}
+
+	if err = os.MkdirAll(*out, 0755); err != nil {
+		log.Errorf("create directory %s err %v", *out, err)
+		return 1
+	}
+
+	for _, m := range ms {
+		name := m.Name + ".json"
+		if m.Template {
+			name = m.Name + elastic.TemplateExt
+		}
+		file := filepath.Join(*out, name)
+
+		if _, err := os.Stat(file); err == nil && !*force {
+			log.Warnf("skip existing mapping file %s", file)
+			continue
+		}
+
+		data, _ := json.MarshalIndent(m.Body, "", "  ")
+		if err = ioutil.WriteFile(file, append(data, '\n'), 0644); err != nil {
+			log.Errorf("write mapping file %s err %v", file, err)
+			return 1
+		}
+
+		log.Infof("wrote mapping file %s", file)
+	}
+
+	return 0
+}
+
+// runReindex starts the rebuild of an index by the running river through its
+// API, waits until it is done and returns the exit code.
+func runReindex(args []string) int {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	addr := fs.String("addr", fmt.Sprintf("127.0.0.1:%d", *apiPort), "API address of the running river")
+	deleteOld := fs.Bool("delete-old", false, "Delete the old index once the alias is swapped")
+	poll := fs.Duration("poll", 5*time.Second, "Interval of the progress checks")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] reindex [-addr host:port] [-delete-old] index\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	index := fs.Arg(0)
+
+	body, _ := json.Marshal(map[string]interface{}{"index": index, "delete_old": *deleteOld})
+	if err := callAPI(http.MethodPost, "http://"+*addr+"/reindex", body, nil); err != nil {
+		log.Errorf("start reindex %s err %v", index, err)
+		return 1
+	}
+
+	for {
+		time.Sleep(*poll)
+
+		var resp struct {
+			Reindexes []*river.ReindexStatus `json:"reindexes"`
+		}
+		if err := callAPI(http.MethodGet, "http://"+*addr+"/reindex", nil, &resp); err != nil {
+			log.Errorf("get reindex %s err %v", index, err)
+			return 1
+		}
+
+		var st *river.ReindexStatus
+		for _, s := range resp.Reindexes {
+			if s.Alias == index {
+				st = s
+			}
+		}
+
+		switch {
+		case st == nil:
+			// The river was restarted, the rebuild is abandoned.
+			log.Errorf("reindex %s is not running", index)
+			return 1
+		case st.Running:
+			log.Infof("reindex %s into %s, %d rows", index, st.Index, st.Rows)
+		default:
+			e := json.NewEncoder(os.Stdout)
+			e.SetIndent("", "  ")
+			e.Encode(st)
+
+			if len(st.Error) > 0 {
+				return 1
+			}
+			return 0
+		}
+	}
+}
+
+// callAPI sends the request with the API token and decodes the response into
+// ret if it is not nil.
+func callAPI(method string, url string, body []byte, ret interface{}) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+*apiToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return errors.Errorf("status %d: %s", resp.StatusCode, data)
+	}
+
+	if ret == nil {
+		return nil
+	}
+
+	return errors.Trace(json.Unmarshal(data, ret))
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -27,6 +28,9 @@ type Client struct {
 	User     string
 	Password string
 
+	// DocType is the mapping type of the bulk requests, omitted if empty.
+	DocType string
+
 	c *http.Client
 }
 
@@ -37,6 +41,14 @@ type ClientConfig struct {
 	User        string
 	Password    string
 	MappingsDir string
+	// Aliases creates the indices of the mappings directory as versioned
+	// indices behind an alias with the name of the mapping.
+	Aliases bool
+	// AllowIncompatibleMappings starts even if a changed mapping file can not
+	// be applied to the existing index, the compatible changes are applied.
+	AllowIncompatibleMappings bool
+	// OmitType omits the mapping type in bulk requests.
+	OmitType bool
 }
 
 const (
@@ -92,29 +104,79 @@ func waitForES(url, user, pass string) error {
 	return nil
 }
 
-func createIndexes(url, mappingsDir, user, pass string) error {
+// ReadMappings reads the index bodies of the mappings directory by index
+// name, the file name without extension. Index templates are skipped.
+func ReadMappings(mappingsDir string) (map[string]string, error) {
 	// List available mappings.
 	files, filesErr := ioutil.ReadDir(mappingsDir)
 	if filesErr != nil {
-		return filesErr
+		return nil, filesErr
 	}
 
 	idxMappings := make(map[string]string)
 
 	for _, f := range files {
 		fullFileName := f.Name()
+		if strings.HasSuffix(fullFileName, TemplateExt) {
+			continue
+		}
+
 		idxName := fullFileName[:len(fullFileName)-len(path.Ext(fullFileName))]
 
 		// Read mappings file.
 		b, bErr := ioutil.ReadFile(path.Join(mappingsDir, fullFileName))
 		if bErr != nil {
-			return bErr
+			return nil, bErr
 		}
 
 		// Populate map with index name and the corresponding mapping JSON.
 		idxMappings[idxName] = string(b)
 	}
 
+	return idxMappings, nil
+}
+
+// VersionedIndex returns the name of the index created at t for the alias.
+func VersionedIndex(alias string, t time.Time) string {
+	return fmt.Sprintf("%s_%s", alias, t.UTC().Format("20060102150405"))
+}
+
+// AliasBody adds the alias to the index body.
+func AliasBody(body string, alias string) (string, error) {
+	m := make(map[string]interface{})
+	if len(strings.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal([]byte(body), &m); err != nil {
+			return "", err
+		}
+	}
+
+	aliases, _ := m["aliases"].(map[string]interface{})
+	if aliases == nil {
+		aliases = make(map[string]interface{})
+	}
+	aliases[alias] = map[string]interface{}{}
+	m["aliases"] = aliases
+
+	b, err := json.Marshal(m)
+	return string(b), err
+}
+
+// createIndexes applies the index templates of the mappings directory and
+// creates the missing indices, the changed mappings of the existing ones are
+// migrated.
+func createIndexes(c *Client, conf *ClientConfig) error {
+	url, user, pass := c.Protocol+"://"+c.Addr, c.User, c.Password
+
+	idxMappings, err := ReadMappings(conf.MappingsDir)
+	if err != nil {
+		return err
+	}
+
+	templates, err := ReadTemplates(conf.MappingsDir)
+	if err != nil {
+		return err
+	}
+
 	// Connect to ES.
 	ctx := context.Background()
 
@@ -130,7 +192,21 @@ func createIndexes(url, mappingsDir, user, pass string) error {
 
 	log.Infof("ES responded with code %d and version %s", esCode, esInfo.Version.Number)
 
-	// Create indexes, report existing ones.
+	// Templates are applied first as they may match the created indices.
+	for name, templateJSON := range templates {
+		body := make(map[string]interface{})
+		if err = json.Unmarshal([]byte(templateJSON), &body); err != nil {
+			return fmt.Errorf("Invalid index template %s: %v", name, err)
+		}
+
+		if err = c.PutTemplate(name, body); err != nil {
+			return fmt.Errorf("Failed to put index template %s: %v", name, err)
+		}
+
+		log.Infof("Index template %s applied", name)
+	}
+
+	// Create indexes, migrate existing ones.
 	for idxName, mappingsJSON := range idxMappings {
 		start := time.Now()
 
@@ -140,10 +216,22 @@ func createIndexes(url, mappingsDir, user, pass string) error {
 		}
 
 		if esIdxExists {
-			log.Infof("Index %s already exists, can not apply mappings (%v)", idxName, time.Since(start))
+			if err = c.migrateIndex(idxName, mappingsJSON, conf.AllowIncompatibleMappings); err != nil {
+				return err
+			}
+
+			log.Infof("Index %s already exists, mappings migrated (%v)", idxName, time.Since(start))
 			continue
 		}
 
+		if conf.Aliases {
+			alias := idxName
+			idxName = VersionedIndex(alias, start)
+			if mappingsJSON, err = AliasBody(mappingsJSON, alias); err != nil {
+				return fmt.Errorf("Invalid mappings of index %s: %v", alias, err)
+			}
+		}
+
 		log.Infof("Processing index %s ...", idxName)
 		createESIndex, createESIndexErr := esClient.CreateIndex(idxName).BodyString(mappingsJSON).Do(ctx)
 		if createESIndexErr != nil {
@@ -168,6 +256,10 @@ func NewClient(conf *ClientConfig) (*Client, error) {
 	c.User = conf.User
 	c.Password = conf.Password
 
+	if !conf.OmitType {
+		c.DocType = "_doc"
+	}
+
 	if conf.HTTPS {
 		c.Protocol = "https"
 		tr := &http.Transport{
@@ -184,9 +276,11 @@ func NewClient(conf *ClientConfig) (*Client, error) {
 		return nil, err
 	}
 
-	err = createIndexes(c.Protocol+"://"+c.Addr, conf.MappingsDir, c.User, c.Password)
-	if err != nil {
-		return nil, err
+	if len(conf.MappingsDir) > 0 {
+		err = createIndexes(c, conf)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return c, nil
@@ -197,6 +291,7 @@ type ResponseItem struct {
 	ID      string                 `json:"_id"`
 	Index   string                 `json:"_index"`
 	Type    string                 `json:"_type"`
+	Routing string                 `json:"_routing"`
 	Version int                    `json:"_version"`
 	Found   bool                   `json:"found"`
 	Source  map[string]interface{} `json:"_source"`
@@ -222,13 +317,30 @@ type BulkRequest struct {
 	Index    string
 	ID       string
 	Pipeline string
+	Routing  string
+
+	// Version is sent with VersionType if not 0.
+	Version     int64
+	VersionType string
 
 	Data map[string]interface{}
+
+	// Script updates the document instead of Data if set.
+	Script *Script
+	// Upsert is indexed by an update if the document does not exist.
+	Upsert map[string]interface{}
+}
+
+// Script is a script run by the update action.
+type Script struct {
+	Source string                 `json:"source"`
+	Lang   string                 `json:"lang,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
 }
 
-func (r *BulkRequest) bulk(buf *bytes.Buffer) error {
-	meta := make(map[string]map[string]string)
-	metaData := make(map[string]string)
+func (r *BulkRequest) bulk(buf *bytes.Buffer, docType string) error {
+	meta := make(map[string]map[string]interface{})
+	metaData := make(map[string]interface{})
 
 	if len(r.Index) > 0 {
 		metaData["_index"] = r.Index
@@ -242,7 +354,18 @@ func (r *BulkRequest) bulk(buf *bytes.Buffer) error {
 		metaData["pipeline"] = r.Pipeline
 	}
 
-	metaData["_type"] = "_doc"
+	if len(r.Routing) > 0 {
+		metaData["routing"] = r.Routing
+	}
+
+	if r.Version > 0 {
+		metaData["version"] = r.Version
+		metaData["version_type"] = r.VersionType
+	}
+
+	if len(docType) > 0 {
+		metaData["_type"] = docType
+	}
 
 	meta[r.Action] = metaData
 
@@ -261,6 +384,14 @@ func (r *BulkRequest) bulk(buf *bytes.Buffer) error {
 		doc := map[string]interface{}{
 			"doc": r.Data,
 		}
+		if r.Script != nil {
+			doc = map[string]interface{}{
+				"script": r.Script,
+			}
+		}
+		if r.Upsert != nil {
+			doc["upsert"] = r.Upsert
+		}
 		data, err = json.Marshal(doc)
 		if err != nil {
 			return errors.Trace(err)
@@ -372,7 +503,7 @@ func (c *Client) DoBulk(url string, items []*BulkRequest) (*BulkResponse, error)
 	var buf bytes.Buffer
 
 	for _, item := range items {
-		if err := item.bulk(&buf); err != nil {
+		if err := item.bulk(&buf, c.DocType); err != nil {
 			return nil, errors.Trace(err)
 		}
 	}
@@ -453,6 +584,62 @@ func (c *Client) GetMapping(index string) (*MappingResponse, error) {
 	return ret, errors.Trace(err)
 }
 
+// CreateIndex creates the index with the body holding its settings,
+// mappings and aliases.
+func (c *Client) CreateIndex(index string, body map[string]interface{}) error {
+	reqURL := fmt.Sprintf("%s://%s/%s", c.Protocol, c.Addr,
+		url.QueryEscape(index))
+
+	_, err := c.doJSON("PUT", reqURL, body, &struct{}{})
+	return errors.Trace(err)
+}
+
+// AliasIndices returns the indices of the alias, nil if there is no such alias.
+func (c *Client) AliasIndices(alias string) ([]string, error) {
+	reqURL := fmt.Sprintf("%s://%s/_alias/%s", c.Protocol, c.Addr,
+		url.QueryEscape(alias))
+
+	ret := make(map[string]interface{})
+	code, err := c.doJSON("GET", reqURL, nil, &ret)
+	if code == http.StatusNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	indices := make([]string, 0, len(ret))
+	for index := range ret {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+
+	return indices, nil
+}
+
+// AliasAction is an action of UpdateAliases, one of add, remove and remove_index.
+type AliasAction struct {
+	Action string
+	Index  string
+	Alias  string
+}
+
+// UpdateAliases runs the actions atomically.
+func (c *Client) UpdateAliases(actions []*AliasAction) error {
+	reqURL := fmt.Sprintf("%s://%s/_aliases", c.Protocol, c.Addr)
+
+	body := make([]interface{}, 0, len(actions))
+	for _, a := range actions {
+		params := map[string]interface{}{"index": a.Index}
+		if len(a.Alias) > 0 {
+			params["alias"] = a.Alias
+		}
+		body = append(body, map[string]interface{}{a.Action: params})
+	}
+
+	_, err := c.doJSON("POST", reqURL, map[string]interface{}{"actions": body}, &struct{}{})
+	return errors.Trace(err)
+}
+
 // DeleteIndex deletes the index.
 func (c *Client) DeleteIndex(index string) error {
 	reqURL := fmt.Sprintf("%s://%s/%s", c.Protocol, c.Addr,
@@ -542,3 +729,122 @@ func (c *Client) IndexBulk(index string, items []*BulkRequest) (*BulkResponse, e
 
 	return c.DoBulk(reqURL, items)
 }
+
+// MGetResponse is the response for the multi get request.
+type MGetResponse struct {
+	Code int
+	Docs []*ResponseItem `json:"docs"`
+}
+
+// MGetDoc is a document to get, the routing is needed for a routed document.
+// The index is needed if the request has no index.
+type MGetDoc struct {
+	Index   string `json:"_index,omitempty"`
+	ID      string `json:"_id"`
+	Routing string `json:"routing,omitempty"`
+}
+
+// MGet gets the documents in one request, the index of every document is
+// used if index is empty.
+func (c *Client) MGet(index string, docs []*MGetDoc) (*MGetResponse, error) {
+	reqURL := fmt.Sprintf("%s://%s/_mget", c.Protocol, c.Addr)
+	if len(index) > 0 {
+		reqURL = fmt.Sprintf("%s://%s/%s/_doc/_mget", c.Protocol, c.Addr,
+			url.QueryEscape(index))
+	}
+
+	ret := new(MGetResponse)
+	code, err := c.doJSON("POST", reqURL, map[string]interface{}{"docs": docs}, ret)
+	ret.Code = code
+
+	return ret, errors.Trace(err)
+}
+
+// SearchResponse is the response for the search and scroll requests.
+type SearchResponse struct {
+	Code     int
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []*ResponseItem `json:"hits"`
+	} `json:"hits"`
+}
+
+// Search searches the index, a scroll is kept open for the given time if it is not 0.
+func (c *Client) Search(index string, body map[string]interface{}, scroll time.Duration) (*SearchResponse, error) {
+	reqURL := fmt.Sprintf("%s://%s/%s/_search", c.Protocol, c.Addr,
+		url.QueryEscape(index))
+	if scroll > 0 {
+		reqURL += "?scroll=" + scrollTime(scroll)
+	}
+
+	ret := new(SearchResponse)
+	code, err := c.doJSON("POST", reqURL, body, ret)
+	ret.Code = code
+
+	return ret, errors.Trace(err)
+}
+
+// Scroll gets the next page of a search started with a scroll.
+func (c *Client) Scroll(scrollID string, scroll time.Duration) (*SearchResponse, error) {
+	reqURL := fmt.Sprintf("%s://%s/_search/scroll", c.Protocol, c.Addr)
+
+	ret := new(SearchResponse)
+	code, err := c.doJSON("POST", reqURL, map[string]interface{}{
+		"scroll":    scrollTime(scroll),
+		"scroll_id": scrollID,
+	}, ret)
+	ret.Code = code
+
+	return ret, errors.Trace(err)
+}
+
+// ClearScroll releases the scroll.
+func (c *Client) ClearScroll(scrollID string) error {
+	reqURL := fmt.Sprintf("%s://%s/_search/scroll", c.Protocol, c.Addr)
+
+	r, err := c.Do("DELETE", reqURL, map[string]interface{}{"scroll_id": scrollID})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if r.Code == http.StatusOK || r.Code == http.StatusNotFound {
+		return nil
+	}
+
+	return errors.Errorf("Error: %s, code: %d", http.StatusText(r.Code), r.Code)
+}
+
+func scrollTime(d time.Duration) string {
+	return fmt.Sprintf("%ds", int(d/time.Second))
+}
+
+// doJSON sends the request with body to ES and decodes the response into ret
+// if the request succeeded.
+func (c *Client) doJSON(method string, url string, body map[string]interface{}, ret interface{}) (int, error) {
+	buf := bytes.NewBuffer(nil)
+	if body != nil {
+		bodyData, err := json.Marshal(body)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		buf = bytes.NewBuffer(bodyData)
+	}
+
+	resp, err := c.DoRequest(method, url, buf)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, errors.Trace(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, errors.Errorf("Error: %s, code: %d, body: %s", http.StatusText(resp.StatusCode), resp.StatusCode, data)
+	}
+
+	return resp.StatusCode, errors.Trace(json.Unmarshal(data, ret))
+}
@@ -91,3 +91,36 @@ func (s *elasticTestSuite) TestSimple(c *C) {
 	c.Assert(resp.Code, Equals, 200)
 	c.Assert(resp.Errors, Equals, false)
 }
+
+func (s *elasticTestSuite) TestAlias(c *C) {
+	alias := "test-alias"
+	old, index := alias+"_1", alias+"_2"
+
+	// Delete before running the test.
+	s.c.DeleteIndex(old)
+	s.c.DeleteIndex(index)
+
+	err := s.c.CreateIndex(old, map[string]interface{}{"aliases": map[string]interface{}{alias: map[string]interface{}{}}})
+	c.Assert(err, IsNil)
+
+	err = s.c.CreateIndex(index, map[string]interface{}{})
+	c.Assert(err, IsNil)
+
+	indices, err := s.c.AliasIndices(alias)
+	c.Assert(err, IsNil)
+	c.Assert(indices, DeepEquals, []string{old})
+
+	err = s.c.UpdateAliases([]*AliasAction{
+		{Action: "add", Index: index, Alias: alias},
+		{Action: "remove", Index: old, Alias: alias},
+	})
+	c.Assert(err, IsNil)
+
+	indices, err = s.c.AliasIndices(alias)
+	c.Assert(err, IsNil)
+	c.Assert(indices, DeepEquals, []string{index})
+
+	indices, err = s.c.AliasIndices("test-no-alias")
+	c.Assert(err, IsNil)
+	c.Assert(indices, IsNil)
+}
@@ -0,0 +1,536 @@
+package elastic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+)
+
+// TemplateExt is the extension of the index template files in the mappings
+// directory, e.g. events.template.json is the template events.
+const TemplateExt = ".template.json"
+
+// staticSettings are the index settings which can only be set when the index
+// is created, the ones ending with a dot are prefixes.
+var staticSettings = []string{
+	"index.number_of_shards",
+	"index.number_of_routing_shards",
+	"index.routing_partition_size",
+	"index.codec",
+	"index.shard.check_on_startup",
+	"index.analysis.",
+	"index.sort.",
+}
+
+// updatableParams are the field mapping parameters which can be changed on
+// an existing field.
+var updatableParams = map[string]bool{
+	"ignore_above":          true,
+	"search_analyzer":       true,
+	"search_quote_analyzer": true,
+	"eager_global_ordinals": true,
+	"boost":                 true,
+}
+
+// updatableMappingParams are the parameters of the mapping which can be
+// changed on an existing index.
+var updatableMappingParams = map[string]bool{
+	"dynamic":           true,
+	"date_detection":    true,
+	"numeric_detection": true,
+	"dynamic_templates": true,
+	"_meta":             true,
+}
+
+// paramDefaults are the values of the field mapping parameters ES does not
+// return if they are not set.
+var paramDefaults = map[string]string{
+	"index":                 "true",
+	"doc_values":            "true",
+	"store":                 "false",
+	"norms":                 "true",
+	"enabled":               "true",
+	"coerce":                "true",
+	"ignore_malformed":      "false",
+	"eager_global_ordinals": "false",
+	"include_in_parent":     "false",
+	"include_in_root":       "false",
+}
+
+// typeParamDefaults are the defaults of the field mapping parameters by field
+// type, where they differ from paramDefaults or only apply to the type.
+var typeParamDefaults = map[string]map[string]string{
+	"keyword":    {"norms": "false", "index_options": "docs"},
+	"text":       {"index_options": "positions"},
+	"date":       {"format": "strict_date_optional_time||epoch_millis"},
+	"date_nanos": {"format": "strict_date_optional_time_nanos||epoch_millis"},
+}
+
+// paramDefault returns the default of the parameter of a field of the type,
+// ok is false if it has none.
+func paramDefault(fieldType string, param string) (string, bool) {
+	if def, ok := typeParamDefaults[fieldType][param]; ok {
+		return def, true
+	}
+
+	def, ok := paramDefaults[param]
+	return def, ok
+}
+
+// MappingDiff is the difference of an index to its mapping file.
+type MappingDiff struct {
+	// Mapping holds the added fields and the changed parameters to put, nil
+	// if nothing changed.
+	Mapping map[string]interface{}
+	// Settings holds the changed dynamic settings to put, nil if nothing changed.
+	Settings map[string]interface{}
+	// Changes describes the changes to apply.
+	Changes []string
+	// Incompatible describes the changes which need a new index.
+	Incompatible []string
+}
+
+// DiffIndex compares the index body of a mapping file with the mapping and
+// the flattened settings of an existing index.
+func DiffIndex(body map[string]interface{}, mapping map[string]interface{}, settings map[string]string) *MappingDiff {
+	d := new(MappingDiff)
+
+	want, _ := body["mappings"].(map[string]interface{})
+	want = typelessMapping(want)
+	if mapping == nil {
+		mapping = make(map[string]interface{})
+	}
+
+	for _, k := range sortedKeys(want) {
+		v := want[k]
+		if k == "properties" {
+			have, _ := mapping["properties"].(map[string]interface{})
+			if props := d.diffProperties("", v, have); props != nil {
+				d.put("properties", props)
+			}
+			continue
+		}
+
+		if sameValue(v, mapping[k]) {
+			continue
+		}
+
+		if updatableMappingParams[k] {
+			d.put(k, v)
+			d.Changes = append(d.Changes, fmt.Sprintf("set %s to %s", k, jsonString(v)))
+		} else {
+			d.Incompatible = append(d.Incompatible, fmt.Sprintf("%s is %s, mapping file %s", k, jsonString(mapping[k]), jsonString(v)))
+		}
+	}
+
+	wantSettings := make(map[string]string)
+	if s, ok := body["settings"].(map[string]interface{}); ok {
+		flattenSettings("", s, wantSettings)
+	}
+
+	for _, k := range sortedStrings(wantSettings) {
+		v := wantSettings[k]
+		have, ok := settings[k]
+		if ok && have == v {
+			continue
+		}
+
+		if isStaticSetting(k) {
+			if !ok {
+				have = "not set"
+			}
+			d.Incompatible = append(d.Incompatible, fmt.Sprintf("setting %s is %s, mapping file %s", k, have, v))
+			continue
+		}
+
+		if d.Settings == nil {
+			d.Settings = make(map[string]interface{})
+		}
+		d.Settings[k] = v
+		d.Changes = append(d.Changes, fmt.Sprintf("set setting %s to %s", k, v))
+	}
+
+	return d
+}
+
+func (d *MappingDiff) put(k string, v interface{}) {
+	if d.Mapping == nil {
+		d.Mapping = make(map[string]interface{})
+	}
+	d.Mapping[k] = v
+}
+
+// diffProperties compares the fields of the mapping file with the existing
+// ones and returns the fields to put, nil if nothing changed. Fields only in
+// the index are kept.
+func (d *MappingDiff) diffProperties(prefix string, want interface{}, have map[string]interface{}) map[string]interface{} {
+	fields, _ := want.(map[string]interface{})
+
+	var put map[string]interface{}
+	for _, name := range sortedKeys(fields) {
+		field := prefix + name
+
+		w, ok := fields[name].(map[string]interface{})
+		if !ok {
+			d.Incompatible = append(d.Incompatible, fmt.Sprintf("field %s is no object in the mapping file", field))
+			continue
+		}
+
+		if put == nil {
+			put = make(map[string]interface{})
+		}
+
+		h, ok := have[name].(map[string]interface{})
+		if !ok {
+			put[name] = w
+			d.Changes = append(d.Changes, fmt.Sprintf("add field %s", field))
+			continue
+		}
+
+		wt := fieldType(w)
+		if ht := fieldType(h); wt != ht {
+			d.Incompatible = append(d.Incompatible, fmt.Sprintf("field %s has type %s, mapping file %s", field, ht, wt))
+			continue
+		}
+
+		changed := false
+		fieldPut := make(map[string]interface{}, len(w))
+		for _, k := range sortedKeys(w) {
+			v := w[k]
+			switch k {
+			case "properties", "fields":
+				sub, _ := h[k].(map[string]interface{})
+				if p := d.diffProperties(field+".", v, sub); p != nil {
+					fieldPut[k] = p
+					changed = true
+				}
+				continue
+			}
+
+			// The parameters are sent again with a changed sub field.
+			fieldPut[k] = v
+
+			hv, ok := h[k]
+			if !ok && k == "type" {
+				// An object has no type.
+				continue
+			}
+			if !ok {
+				if def, ok := paramDefault(wt, k); ok && sameValue(v, def) {
+					continue
+				}
+			} else if sameValue(v, hv) {
+				continue
+			}
+
+			if updatableParams[k] {
+				changed = true
+				d.Changes = append(d.Changes, fmt.Sprintf("set %s of field %s to %s", k, field, jsonString(v)))
+			} else {
+				have := "not set"
+				if ok {
+					have = jsonString(hv)
+				}
+				d.Incompatible = append(d.Incompatible, fmt.Sprintf("field %s %s is %s, mapping file %s", field, k, have, jsonString(v)))
+
+				// The existing value is kept if incompatible changes are allowed.
+				if ok {
+					fieldPut[k] = hv
+				} else {
+					delete(fieldPut, k)
+				}
+			}
+		}
+
+		if changed {
+			put[name] = fieldPut
+		}
+	}
+
+	if len(put) == 0 {
+		return nil
+	}
+
+	return put
+}
+
+// fieldType returns the type of the field mapping, object if it has none.
+func fieldType(m map[string]interface{}) string {
+	if t, ok := m["type"].(string); ok {
+		return t
+	}
+
+	return "object"
+}
+
+// typelessMapping returns the mapping of the single type of a mapping with
+// a type like {"_doc": {"properties": ...}}.
+func typelessMapping(m map[string]interface{}) map[string]interface{} {
+	if len(m) != 1 {
+		return m
+	}
+
+	for k, v := range m {
+		if t, ok := v.(map[string]interface{}); ok && k != "properties" && (!strings.HasPrefix(k, "_") || k == "_doc") {
+			return t
+		}
+	}
+
+	return m
+}
+
+// flattenSettings flattens the settings to keys like index.number_of_replicas.
+func flattenSettings(prefix string, m map[string]interface{}, out map[string]string) {
+	for k, v := range m {
+		key := prefix + k
+		if len(prefix) == 0 && !strings.HasPrefix(key, "index.") && key != "index" {
+			key = "index." + key
+		}
+
+		if sub, ok := v.(map[string]interface{}); ok {
+			flattenSettings(key+".", sub, out)
+			continue
+		}
+
+		out[key] = settingString(v)
+	}
+}
+
+func settingString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, p := range v {
+			parts = append(parts, settingString(p))
+		}
+		return strings.Join(parts, ",")
+	}
+
+	return fmt.Sprint(v)
+}
+
+func isStaticSetting(k string) bool {
+	for _, s := range staticSettings {
+		if k == s || (strings.HasSuffix(s, ".") && strings.HasPrefix(k, s)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameValue compares JSON values, ES may return booleans and numbers as
+// strings, and a single value as an array like copy_to.
+func sameValue(a, b interface{}) bool {
+	return jsonString(normalizeValue(a)) == jsonString(normalizeValue(b))
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = normalizeValue(e)
+		}
+		return m
+	case []interface{}:
+		if len(v) == 1 {
+			return normalizeValue(v[0])
+		}
+
+		l := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			l = append(l, normalizeValue(e))
+		}
+		return l
+	case nil:
+		return nil
+	}
+
+	return fmt.Sprint(v)
+}
+
+func jsonString(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func sortedStrings(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// ReadTemplates reads the index templates of the mappings directory by name.
+func ReadTemplates(mappingsDir string) (map[string]string, error) {
+	files, err := ioutil.ReadDir(mappingsDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	templates := make(map[string]string)
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), TemplateExt) {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(path.Join(mappingsDir, f.Name()))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		templates[strings.TrimSuffix(f.Name(), TemplateExt)] = string(b)
+	}
+
+	return templates, nil
+}
+
+// migrateIndex applies the changes of the mapping file to the existing index,
+// or to every index of the alias. Nothing is applied if a change needs a new
+// index, unless allowIncompatible is set.
+func (c *Client) migrateIndex(index string, body string, allowIncompatible bool) error {
+	want := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(body), &want); err != nil {
+		return errors.Annotatef(err, "mapping file of %s", index)
+	}
+
+	mappings, err := c.GetIndexMappings(index)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	settings, err := c.GetIndexSettings(index)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	diffs := make(map[string]*MappingDiff, len(mappings))
+	var incompatible []string
+	for _, concrete := range sortedKeys(mappings) {
+		m, _ := mappings[concrete].(map[string]interface{})
+		d := DiffIndex(want, m, settings[concrete])
+		diffs[concrete] = d
+
+		for _, change := range d.Incompatible {
+			log.Errorf("index %s: incompatible change, %s", concrete, change)
+			incompatible = append(incompatible, fmt.Sprintf("%s: %s", concrete, change))
+		}
+	}
+
+	if len(incompatible) > 0 && !allowIncompatible {
+		return errors.Errorf("mapping file of %s has incompatible changes, reindex it or allow incompatible mappings: %s",
+			index, strings.Join(incompatible, "; "))
+	}
+
+	for _, concrete := range sortedKeys(mappings) {
+		d := diffs[concrete]
+		for _, change := range d.Changes {
+			log.Infof("index %s: %s", concrete, change)
+		}
+
+		if d.Mapping != nil {
+			if err = c.PutMapping(concrete, d.Mapping); err != nil {
+				return errors.Annotatef(err, "put mapping of %s", concrete)
+			}
+		}
+
+		if d.Settings != nil {
+			if err = c.PutSettings(concrete, d.Settings); err != nil {
+				return errors.Annotatef(err, "put settings of %s", concrete)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetIndexMappings returns the mappings of the index, or of every index of
+// the alias, by index name. The mappings have no type.
+func (c *Client) GetIndexMappings(index string) (map[string]interface{}, error) {
+	reqURL := fmt.Sprintf("%s://%s/%s/_mapping", c.Protocol, c.Addr, url.QueryEscape(index))
+
+	ret := make(map[string]struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	})
+	if _, err := c.doJSON("GET", reqURL, nil, &ret); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	mappings := make(map[string]interface{}, len(ret))
+	for k, v := range ret {
+		mappings[k] = typelessMapping(v.Mappings)
+	}
+
+	return mappings, nil
+}
+
+// GetIndexSettings returns the flattened settings of the index, or of every
+// index of the alias, by index name.
+func (c *Client) GetIndexSettings(index string) (map[string]map[string]string, error) {
+	reqURL := fmt.Sprintf("%s://%s/%s/_settings", c.Protocol, c.Addr, url.QueryEscape(index))
+
+	ret := make(map[string]struct {
+		Settings map[string]interface{} `json:"settings"`
+	})
+	if _, err := c.doJSON("GET", reqURL, nil, &ret); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	settings := make(map[string]map[string]string, len(ret))
+	for k, v := range ret {
+		settings[k] = make(map[string]string)
+		flattenSettings("", v.Settings, settings[k])
+	}
+
+	return settings, nil
+}
+
+// PutMapping adds the fields and parameters of the mapping to the index.
+func (c *Client) PutMapping(index string, mapping map[string]interface{}) error {
+	reqURL := fmt.Sprintf("%s://%s/%s/_mapping", c.Protocol, c.Addr, url.QueryEscape(index))
+	if len(c.DocType) > 0 {
+		reqURL += "/" + url.QueryEscape(c.DocType)
+	}
+
+	_, err := c.doJSON("PUT", reqURL, mapping, &struct{}{})
+	return errors.Trace(err)
+}
+
+// PutSettings updates the dynamic settings of the index.
+func (c *Client) PutSettings(index string, settings map[string]interface{}) error {
+	reqURL := fmt.Sprintf("%s://%s/%s/_settings", c.Protocol, c.Addr, url.QueryEscape(index))
+
+	_, err := c.doJSON("PUT", reqURL, settings, &struct{}{})
+	return errors.Trace(err)
+}
+
+// PutTemplate creates or replaces the index template.
+func (c *Client) PutTemplate(name string, body map[string]interface{}) error {
+	reqURL := fmt.Sprintf("%s://%s/_template/%s", c.Protocol, c.Addr, url.QueryEscape(name))
+
+	_, err := c.doJSON("PUT", reqURL, body, &struct{}{})
+	return errors.Trace(err)
+}
@@ -0,0 +1,156 @@
+package elastic
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, s string) map[string]interface{} {
+	m := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestDiffIndex(t *testing.T) {
+	body := decodeJSON(t, `{
+		"settings": {"number_of_shards": 1, "index": {"number_of_replicas": 2, "refresh_interval": "1s"}},
+		"mappings": {"_doc": {
+			"dynamic": "strict",
+			"properties": {
+				"id": {"type": "long"},
+				"title": {"type": "text", "index": true, "fields": {"raw": {"type": "keyword", "ignore_above": 512}}},
+				"user": {"properties": {"name": {"type": "keyword"}, "email": {"type": "keyword"}}},
+				"price": {"type": "double"},
+				"tags": {"type": "keyword", "doc_values": false}
+			}
+		}}
+	}`)
+
+	mapping := decodeJSON(t, `{
+		"dynamic": "true",
+		"properties": {
+			"id": {"type": "long"},
+			"title": {"type": "text", "fields": {"raw": {"type": "keyword", "ignore_above": 256}}},
+			"user": {"properties": {"name": {"type": "keyword"}}},
+			"price": {"type": "float"},
+			"tags": {"type": "keyword"},
+			"extra": {"type": "text"}
+		}
+	}`)
+
+	settings := map[string]string{
+		"index.number_of_shards":   "5",
+		"index.number_of_replicas": "2",
+		"index.uuid":               "x",
+	}
+
+	d := DiffIndex(body, mapping, settings)
+
+	expectMapping := decodeJSON(t, `{
+		"dynamic": "strict",
+		"properties": {
+			"title": {"type": "text", "index": true, "fields": {"raw": {"type": "keyword", "ignore_above": 512}}},
+			"user": {"properties": {"email": {"type": "keyword"}}}
+		}
+	}`)
+	if !reflect.DeepEqual(d.Mapping, expectMapping) {
+		t.Errorf("Expected: mapping is %v, but: was %v", expectMapping, d.Mapping)
+	}
+
+	expectSettings := map[string]interface{}{"index.refresh_interval": "1s"}
+	if !reflect.DeepEqual(d.Settings, expectSettings) {
+		t.Errorf("Expected: settings is %v, but: was %v", expectSettings, d.Settings)
+	}
+
+	expectChanges := []string{
+		`set dynamic to "strict"`,
+		`set ignore_above of field title.raw to 512`,
+		`add field user.email`,
+		`set setting index.refresh_interval to 1s`,
+	}
+	if !reflect.DeepEqual(d.Changes, expectChanges) {
+		t.Errorf("Expected: changes are %q, but: was %q", expectChanges, d.Changes)
+	}
+
+	expectIncompatible := []string{
+		`field price has type float, mapping file double`,
+		`field tags doc_values is not set, mapping file false`,
+		`setting index.number_of_shards is 5, mapping file 1`,
+	}
+	if !reflect.DeepEqual(d.Incompatible, expectIncompatible) {
+		t.Errorf("Expected: incompatible changes are %q, but: was %q", expectIncompatible, d.Incompatible)
+	}
+
+	// Nothing changed.
+	d = DiffIndex(decodeJSON(t, `{"mappings": {"properties": {"id": {"type": "long", "store": false}}}}`),
+		decodeJSON(t, `{"properties": {"id": {"type": "long"}}}`), nil)
+	if d.Mapping != nil || d.Settings != nil || len(d.Changes) > 0 || len(d.Incompatible) > 0 {
+		t.Errorf("Expected: no changes, but: was %+v", d)
+	}
+}
+
+func TestDiffIndexDefaults(t *testing.T) {
+	// The mapping as returned by GET _mapping, without the defaults of the
+	// field types and with copy_to as an array.
+	mapping := decodeJSON(t, `{
+		"properties": {
+			"all": {"type": "text"},
+			"body": {"type": "text"},
+			"name": {"type": "keyword", "copy_to": ["all"]},
+			"created_at": {"type": "date"},
+			"updated_at": {"type": "date_nanos"},
+			"price": {"type": "scaled_float", "scaling_factor": 100.0},
+			"likes": {"type": "nested", "properties": {"user": {"type": "keyword"}}}
+		}
+	}`)
+
+	tests := []string{
+		`{"mappings": {"_doc": {"properties": {
+			"all": {"type": "text", "norms": true},
+			"body": {"type": "text", "index_options": "positions"},
+			"name": {"type": "keyword", "norms": false, "index_options": "docs", "copy_to": "all"},
+			"created_at": {"type": "date", "format": "strict_date_optional_time||epoch_millis"},
+			"updated_at": {"type": "date_nanos", "format": "strict_date_optional_time_nanos||epoch_millis"},
+			"price": {"type": "scaled_float", "scaling_factor": 100},
+			"likes": {"type": "nested", "include_in_parent": false, "properties": {"user": {"type": "keyword", "norms": false}}}
+		}}}}`,
+		// ES's own output is no change either.
+		`{"mappings": ` + jsonString(mapping) + `}`,
+	}
+
+	for _, test := range tests {
+		d := DiffIndex(decodeJSON(t, test), mapping, nil)
+		if d.Mapping != nil || len(d.Changes) > 0 || len(d.Incompatible) > 0 {
+			t.Errorf("Body: %s, Expected: no changes, but: was %+v", test, d)
+		}
+	}
+
+	d := DiffIndex(decodeJSON(t, `{"mappings": {"properties": {"name": {"type": "keyword", "norms": true}}}}`), mapping, nil)
+	expectIncompatible := []string{`field name norms is not set, mapping file true`}
+	if !reflect.DeepEqual(d.Incompatible, expectIncompatible) {
+		t.Errorf("Expected: incompatible changes are %q, but: was %q", expectIncompatible, d.Incompatible)
+	}
+}
+
+func TestTypelessMapping(t *testing.T) {
+	tests := []struct {
+		Mapping string
+		Expect  string
+	}{
+		{`{"_doc": {"properties": {}}}`, `{"properties": {}}`},
+		{`{"doc": {"dynamic": false}}`, `{"dynamic": false}`},
+		{`{"properties": {"id": {"type": "long"}}}`, `{"properties": {"id": {"type": "long"}}}`},
+		{`{"_source": {"enabled": false}}`, `{"_source": {"enabled": false}}`},
+		{`{}`, `{}`},
+	}
+
+	for _, test := range tests {
+		if m := typelessMapping(decodeJSON(t, test.Mapping)); !reflect.DeepEqual(m, decodeJSON(t, test.Expect)) {
+			t.Errorf("Mapping: %s, Expected: is %s, but: was %v", test.Mapping, test.Expect, m)
+		}
+	}
+}
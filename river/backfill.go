@@ -0,0 +1,424 @@
+package river
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/fasttrack-solutions/go-mysql/canal"
+	"github.com/fasttrack-solutions/go-mysql/mysql"
+	"github.com/fasttrack-solutions/go-mysql/schema"
+	"github.com/juju/errors"
+	"github.com/shopspring/decimal"
+	"github.com/siddontang/go-log/log"
+)
+
+const defaultBackfillChunkSize = 1000
+
+// ErrBackfillRunning is the error if the table is already being backfilled.
+var ErrBackfillRunning = errors.New("backfill is already running")
+
+// BackfillStatus is the progress of a table backfill.
+type BackfillStatus struct {
+	Rule     string     `json:"rule"`
+	Rows     uint64     `json:"rows"`
+	Running  bool       `json:"running"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Backfill indexes all rows of the rule's table again without a mysqldump.
+// The table is read in primary key order in chunks while the binlog stream
+// keeps running. Row events are held back while a chunk is read, so a change
+// made after the chunk was read is always sent to ES after the chunk and the
+// stale row can not overwrite it.
+func (r *River) Backfill(schema, table string) error {
+	rule, ok := r.getRule(schema, table)
+	if !ok {
+		return ErrRuleNotExist
+	}
+
+	select {
+	case <-r.getCanal().WaitDumpDone():
+	default:
+		return errors.New("can not backfill before dump is done")
+	}
+
+	return r.startBackfill(rule)
+}
+
+func (r *River) startBackfill(rule *Rule) error {
+	if r.ctx.Err() != nil {
+		return errors.New("river is closed")
+	}
+
+	// Indexing the rows again would drop what the script keeps in the documents.
+	if len(rule.Script) > 0 {
+		return errors.Errorf("can not backfill %s, its documents are updated by a script", rule.key())
+	}
+
+	r.backfillsLock.Lock()
+	defer r.backfillsLock.Unlock()
+
+	key := rule.key()
+	if st, ok := r.backfills[key]; ok && st.Running {
+		return ErrBackfillRunning
+	}
+
+	st := &BackfillStatus{Rule: key, Running: true, Started: time.Now()}
+	r.backfills[key] = st
+
+	r.wg.Add(1)
+	go r.backfill(rule.Schema, rule.Table, st)
+
+	return nil
+}
+
+// Backfills returns the status of the started backfills ordered by rule.
+func (r *River) Backfills() []*BackfillStatus {
+	r.backfillsLock.Lock()
+	defer r.backfillsLock.Unlock()
+
+	sts := make([]*BackfillStatus, 0, len(r.backfills))
+	for _, st := range r.backfills {
+		s := *st
+		sts = append(sts, &s)
+	}
+
+	sort.Slice(sts, func(i, j int) bool { return sts[i].Rule < sts[j].Rule })
+
+	return sts
+}
+
+func (r *River) backfill(schema, table string, st *BackfillStatus) {
+	defer r.wg.Done()
+
+	var err error
+	if rule, ok := r.getRule(schema, table); ok && len(rule.Nested) > 0 {
+		err = r.waitParentBackfills(rule)
+	}
+
+	if err == nil {
+		log.Infof("start backfill %s", st.Rule)
+
+		err = r.backfillTable(schema, table, "", func(n int) {
+			r.backfillsLock.Lock()
+			st.Rows += uint64(n)
+			r.backfillsLock.Unlock()
+		})
+	}
+
+	r.backfillsLock.Lock()
+	now := time.Now()
+	st.Running = false
+	st.Finished = &now
+	if err != nil {
+		st.Error = err.Error()
+	}
+	r.backfillsLock.Unlock()
+
+	if err != nil {
+		log.Errorf("backfill %s err %v after %d rows", st.Rule, err, st.Rows)
+		return
+	}
+
+	log.Infof("backfill %s done, %d rows in %v", st.Rule, st.Rows, now.Sub(st.Started))
+}
+
+// waitParentBackfills waits for the running backfills of the rules indexing
+// the documents the nested rule updates, the rows of a nested rule can only be
+// added to existing documents.
+func (r *River) waitParentBackfills(rule *Rule) error {
+	for {
+		var keys []string
+		r.backfillsLock.Lock()
+		for key, st := range r.backfills {
+			if st.Running {
+				keys = append(keys, key)
+			}
+		}
+		r.backfillsLock.Unlock()
+
+		running := false
+		r.rulesLock.RLock()
+		for _, key := range keys {
+			if parent, ok := r.rules[key]; ok && len(parent.Nested) == 0 && parent.Index == rule.Index {
+				running = true
+			}
+		}
+		r.rulesLock.RUnlock()
+
+		if !running {
+			return nil
+		}
+
+		if err := r.sleep(time.Second); err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
+
+// backfillTable indexes the rows of the table into index, or the index of the
+// rule if it is empty, and reports the number of rows of every chunk read.
+func (r *River) backfillTable(schema, table string, index string, progress func(n int)) error {
+	chunkSize := r.c.BackfillChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultBackfillChunkSize
+	}
+
+	var last []interface{}
+	for {
+		if err := r.ctx.Err(); err != nil {
+			return errors.Trace(err)
+		}
+
+		n, next, err := r.backfillChunk(schema, table, index, last, chunkSize)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		progress(n)
+
+		if n < chunkSize {
+			return nil
+		}
+
+		last = next
+	}
+}
+
+// backfillChunk sends the index requests for the rows after the last primary
+// key and returns the number of rows read and the primary key of the last one.
+// The documents are written to index if it is not empty.
+func (r *River) backfillChunk(schema, table string, index string, last []interface{}, limit int) (int, []interface{}, error) {
+	// Hold back row events until the chunk is queued.
+	r.rowsLock.Lock()
+	defer r.rowsLock.Unlock()
+
+	rule, ok := r.getRule(schema, table)
+	if !ok {
+		return 0, nil, ErrRuleNotExist
+	}
+
+	t := rule.TableInfo
+	if len(t.PKColumns) == 0 {
+		return 0, nil, errors.Errorf("%s.%s has no primary key", schema, table)
+	}
+
+	res, err := r.getCanal().Execute(backfillQuery(t, last, limit))
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+
+	rows := res.Values
+	if len(rows) == 0 {
+		return 0, nil, nil
+	}
+
+	reqs, err := r.makeIndexRequests(rule, rows)
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+
+	if len(index) > 0 {
+		for _, req := range reqs {
+			req.Index = index
+		}
+	}
+
+	// The rows are at least as new as the binlog events read so far.
+	setVersions(rule, reqs, r.getCanal().SyncedPosition())
+
+	if err = r.queueRequests(reqs); err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+
+	backfillRowsCounter.WithLabelValues(rule.key()).Add(float64(len(reqs)))
+
+	return len(rows), rowKey(t, rows[len(rows)-1]), nil
+}
+
+// makeIndexRequests makes the index requests for rows read with the text
+// protocol, the rows with no document are skipped.
+func (r *River) makeIndexRequests(rule *Rule, rows [][]interface{}) ([]*sink.Event, error) {
+	reqs := make([]*sink.Event, 0, len(rows))
+	for _, row := range rows {
+		values := backfillValues(rule.TableInfo, row)
+
+		if len(rule.Nested) > 0 {
+			req, err := r.makeNestedEvent(rule, values, values, true)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			if req != nil {
+				reqs = append(reqs, req)
+			}
+			continue
+		}
+
+		if !rule.indexed(values) {
+			continue
+		}
+
+		id, err := r.getDocID(rule, values)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		req := newEvent(rule, id, values)
+		req.Pipeline = rule.Pipeline
+		r.makeInsertReqData(req, rule, values)
+		keepNested(req, rule)
+
+		if r.publishesRows(rule) {
+			req.Row = &sink.RowChange{
+				Schema: rule.Schema,
+				Table:  rule.Table,
+				Action: canal.InsertAction,
+				After:  r.makeRowImage(rule, values),
+			}
+		}
+
+		reqs = append(reqs, req)
+	}
+
+	return reqs, nil
+}
+
+// queueRequests sends the requests after the row events queued so far, or
+// directly to ES if the river is not syncing.
+func (r *River) queueRequests(reqs []*sink.Event) error {
+	reqs = r.dualWrite(reqs)
+
+	if !r.running.Get() {
+		return errors.Trace(r.doBulk(reqs))
+	}
+
+	select {
+	case r.syncCh <- rowsRequest{reqs: reqs}:
+		return nil
+	case <-r.ctx.Done():
+		return errors.Trace(r.ctx.Err())
+	}
+}
+
+// rowKey returns the primary key values of the row.
+func rowKey(t *schema.Table, row []interface{}) []interface{} {
+	key := make([]interface{}, len(t.PKColumns))
+	for i, c := range t.PKColumns {
+		key[i] = row[c]
+	}
+
+	return key
+}
+
+// backfillQuery selects the first limit rows after the primary key last,
+// from the start of the table if last is nil.
+func backfillQuery(t *schema.Table, last []interface{}, limit int) string {
+	var where string
+	if last != nil {
+		where = fmt.Sprintf(" WHERE (%s) > %s", pkColumns(t), sqlTuple(last))
+	}
+
+	return fmt.Sprintf("SELECT %s FROM %s.%s%s ORDER BY %s LIMIT %d",
+		tableColumns(t), quoteName(t.Schema), quoteName(t.Name), where, pkColumns(t), limit)
+}
+
+// keysQuery selects the columns of the rows with the primary keys.
+func keysQuery(t *schema.Table, columns string, keys [][]interface{}) string {
+	tuples := make([]string, 0, len(keys))
+	for _, key := range keys {
+		tuples = append(tuples, sqlTuple(key))
+	}
+
+	return fmt.Sprintf("SELECT %s FROM %s.%s WHERE (%s) IN (%s)",
+		columns, quoteName(t.Schema), quoteName(t.Name), pkColumns(t), strings.Join(tuples, ", "))
+}
+
+func tableColumns(t *schema.Table) string {
+	columns := make([]string, 0, len(t.Columns))
+	for _, c := range t.Columns {
+		columns = append(columns, quoteName(c.Name))
+	}
+
+	return strings.Join(columns, ", ")
+}
+
+func pkColumns(t *schema.Table) string {
+	pks := make([]string, 0, len(t.PKColumns))
+	for _, i := range t.PKColumns {
+		pks = append(pks, quoteName(t.Columns[i].Name))
+	}
+
+	return strings.Join(pks, ", ")
+}
+
+func sqlTuple(values []interface{}) string {
+	literals := make([]string, 0, len(values))
+	for _, v := range values {
+		literals = append(literals, sqlLiteral(v))
+	}
+
+	return "(" + strings.Join(literals, ", ") + ")"
+}
+
+func quoteName(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func sqlLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return "'" + mysql.Escape(string(v)) + "'"
+	case string:
+		return "'" + mysql.Escape(v) + "'"
+	}
+
+	return fmt.Sprintf("%v", v)
+}
+
+// backfillValues converts a row read with the text protocol to the values
+// canal passes for dumped rows, so it is indexed like a dumped row.
+func backfillValues(t *schema.Table, row []interface{}) []interface{} {
+	values := make([]interface{}, len(row))
+
+	for i, v := range row {
+		values[i] = backfillValue(&t.Columns[i], v)
+	}
+
+	return values
+}
+
+func backfillValue(c *schema.TableColumn, v interface{}) interface{} {
+	b, ok := v.([]byte)
+	if !ok {
+		return v
+	}
+
+	s := string(b)
+
+	switch c.Type {
+	case schema.TYPE_NUMBER:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		} else if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return n
+		}
+	case schema.TYPE_FLOAT:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case schema.TYPE_DECIMAL:
+		if d, err := decimal.NewFromString(s); err == nil {
+			return d
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,72 @@
+package river
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fasttrack-solutions/go-mysql/schema"
+	"github.com/shopspring/decimal"
+)
+
+func TestBackfillQuery(t *testing.T) {
+	table := &schema.Table{
+		Schema: "test",
+		Name:   "test_river",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "title", Type: schema.TYPE_STRING},
+			{Name: "tenant", Type: schema.TYPE_STRING},
+		},
+		PKColumns: []int{2, 0},
+	}
+
+	tests := []struct {
+		Last   []interface{}
+		Expect string
+	}{
+		{nil, "SELECT `id`, `title`, `tenant` FROM `test`.`test_river` ORDER BY `tenant`, `id` LIMIT 100"},
+		{[]interface{}{[]byte("it's"), int64(10)},
+			"SELECT `id`, `title`, `tenant` FROM `test`.`test_river` WHERE (`tenant`, `id`) > ('it\\'s', 10) ORDER BY `tenant`, `id` LIMIT 100"},
+	}
+
+	for _, test := range tests {
+		if query := backfillQuery(table, test.Last, 100); query != test.Expect {
+			t.Errorf("Last: %v, Expected: is %s, but: was %s", test.Last, test.Expect, query)
+		}
+	}
+}
+
+func TestBackfillValues(t *testing.T) {
+	table := &schema.Table{
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "big", Type: schema.TYPE_NUMBER},
+			{Name: "price", Type: schema.TYPE_DECIMAL},
+			{Name: "title", Type: schema.TYPE_STRING},
+			{Name: "created", Type: schema.TYPE_DATETIME},
+			{Name: "deleted", Type: schema.TYPE_DATETIME},
+		},
+	}
+
+	row := []interface{}{
+		[]byte("5"),
+		uint64(18446744073709551615),
+		[]byte("1.5"),
+		[]byte("title"),
+		[]byte("2018-01-02 03:04:05"),
+		nil,
+	}
+
+	expect := []interface{}{
+		int64(5),
+		uint64(18446744073709551615),
+		decimal.New(15, -1),
+		"title",
+		"2018-01-02 03:04:05",
+		nil,
+	}
+
+	if values := backfillValues(table, row); !reflect.DeepEqual(values, expect) {
+		t.Errorf("Expected: is %v, but: was %v", expect, values)
+	}
+}
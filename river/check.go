@@ -0,0 +1,676 @@
+package river
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/elastic"
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/fasttrack-solutions/go-mysql/mysql"
+	"github.com/fasttrack-solutions/go-mysql/schema"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+)
+
+const (
+	// checkRecheckDelay is how long to wait before checking the inconsistent
+	// documents again, so the changes made while checking are synced.
+	checkRecheckDelay = 3 * time.Second
+
+	checkScrollTime = 5 * time.Minute
+
+	// maxCheckReportIDs is the number of document IDs kept per kind in a report.
+	maxCheckReportIDs = 100
+)
+
+// CheckOptions are the options of a consistency check.
+type CheckOptions struct {
+	// Tables are the schema.table names to check, all rules if empty.
+	Tables []string
+	// SampleChunks is the number of random chunks checked per rule, the whole
+	// table is checked if 0.
+	SampleChunks int
+	// Repair syncs the missing and different documents again and deletes the extra ones.
+	Repair bool
+}
+
+// CheckReport is the result of checking a rule. The IDs lists hold at most
+// 100 document IDs each.
+type CheckReport struct {
+	Rule string `json:"rule"`
+	// Rows is the number of checked rows.
+	Rows uint64 `json:"rows"`
+	// Docs is the number of documents checked for extra ones, 0 if they can
+	// not be checked for the rule.
+	Docs uint64 `json:"docs"`
+
+	Missing      uint64   `json:"missing"`
+	MissingIDs   []string `json:"missing_ids,omitempty"`
+	Extra        uint64   `json:"extra"`
+	ExtraIDs     []string `json:"extra_ids,omitempty"`
+	Different    uint64   `json:"different"`
+	DifferentIDs []string `json:"different_ids,omitempty"`
+
+	Repaired uint64 `json:"repaired"`
+}
+
+// Consistent returns true if no inconsistent document is found.
+func (r *CheckReport) Consistent() bool {
+	return r.Missing == 0 && r.Extra == 0 && r.Different == 0
+}
+
+func (r *CheckReport) addMissing(id string) {
+	r.Missing++
+	if len(r.MissingIDs) < maxCheckReportIDs {
+		r.MissingIDs = append(r.MissingIDs, id)
+	}
+}
+
+func (r *CheckReport) addExtra(id string) {
+	r.Extra++
+	if len(r.ExtraIDs) < maxCheckReportIDs {
+		r.ExtraIDs = append(r.ExtraIDs, id)
+	}
+}
+
+func (r *CheckReport) addDifferent(id string) {
+	r.Different++
+	if len(r.DifferentIDs) < maxCheckReportIDs {
+		r.DifferentIDs = append(r.DifferentIDs, id)
+	}
+}
+
+// rowDiff is a row whose document is missing or different.
+type rowDiff struct {
+	key     []interface{}
+	id      string
+	missing bool
+}
+
+// Check compares the rules' tables with their documents in ES, rules writing to
+// other sinks, nested rules and script rules are skipped. The tables are
+// read in primary key order in chunks, the documents expected for the rows are
+// made like for the binlog events and compared with the ones fetched with _mget.
+// Inconsistent documents are checked again after a delay to skip the rows
+// changed while checking.
+func (r *River) Check(opts CheckOptions) ([]*CheckReport, error) {
+	var rules []*Rule
+
+	if len(opts.Tables) == 0 {
+		r.rulesLock.RLock()
+		for _, rule := range r.rules {
+			// Only the documents in the Elasticsearch of the river can be fetched.
+			if len(rule.Sink) == 0 && len(rule.Nested) == 0 && len(rule.Script) == 0 {
+				rules = append(rules, rule)
+			}
+		}
+		r.rulesLock.RUnlock()
+
+		sort.Slice(rules, func(i, j int) bool { return rules[i].key() < rules[j].key() })
+	} else {
+		for _, table := range opts.Tables {
+			parts := strings.SplitN(table, ".", 2)
+			if len(parts) != 2 {
+				return nil, errors.Errorf("invalid table %s, must be schema.table", table)
+			}
+
+			rule, ok := r.getRule(parts[0], parts[1])
+			if !ok {
+				return nil, errors.Annotatef(ErrRuleNotExist, "check %s", table)
+			}
+
+			if len(rule.Sink) > 0 {
+				return nil, errors.Errorf("can not check %s, it is written to sink %s", table, rule.Sink)
+			}
+
+			if len(rule.Nested) > 0 {
+				return nil, errors.Errorf("can not check %s, it is nested in %s", table, rule.Index)
+			}
+
+			if len(rule.Script) > 0 {
+				return nil, errors.Errorf("can not check %s, its documents are updated by a script", table)
+			}
+
+			rules = append(rules, rule)
+		}
+	}
+
+	reports := make([]*CheckReport, 0, len(rules))
+	for _, rule := range rules {
+		report, err := r.checkRule(rule, opts)
+		if err != nil {
+			return reports, errors.Annotatef(err, "check %s", rule.key())
+		}
+
+		reports = append(reports, report)
+
+		checkDocsGauge.WithLabelValues(rule.key(), "missing").Set(float64(report.Missing))
+		checkDocsGauge.WithLabelValues(rule.key(), "extra").Set(float64(report.Extra))
+		checkDocsGauge.WithLabelValues(rule.key(), "different").Set(float64(report.Different))
+		checkRepairedCounter.WithLabelValues(rule.key()).Add(float64(report.Repaired))
+	}
+
+	return reports, nil
+}
+
+func (r *River) checkRule(rule *Rule, opts CheckOptions) (*CheckReport, error) {
+	report := &CheckReport{Rule: rule.key()}
+
+	chunkSize := r.c.BackfillChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultBackfillChunkSize
+	}
+
+	if opts.SampleChunks > 0 {
+		for i := 0; i < opts.SampleChunks; i++ {
+			start, err := r.randomKey(rule)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+
+			if _, _, err = r.checkChunk(rule, start, chunkSize, opts.Repair, report); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+	} else {
+		var last []interface{}
+		for {
+			n, next, err := r.checkChunk(rule, last, chunkSize, opts.Repair, report)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+
+			if n < chunkSize {
+				break
+			}
+
+			last = next
+		}
+	}
+
+	if !r.canCheckExtra(rule) {
+		log.Infof("skip checking extra documents of %s, the index is shared or the document ID is not the primary key", rule.key())
+		return report, nil
+	}
+
+	if opts.SampleChunks > 0 {
+		for i := 0; i < opts.SampleChunks; i++ {
+			resp, err := r.es.Search(rule.indexPattern(), map[string]interface{}{
+				"size":    chunkSize,
+				"_source": false,
+				"query": map[string]interface{}{
+					"function_score": map[string]interface{}{"random_score": map[string]interface{}{}},
+				},
+			}, 0)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+
+			if err = r.checkDocs(rule, resp.Hits.Hits, opts.Repair, report); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+
+		return report, nil
+	}
+
+	resp, err := r.es.Search(rule.indexPattern(), map[string]interface{}{
+		"size":    chunkSize,
+		"_source": false,
+		"sort":    []string{"_doc"},
+	}, checkScrollTime)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	defer func() {
+		if len(resp.ScrollID) > 0 {
+			r.es.ClearScroll(resp.ScrollID)
+		}
+	}()
+
+	for len(resp.Hits.Hits) > 0 {
+		if err = r.checkDocs(rule, resp.Hits.Hits, opts.Repair, report); err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		if resp, err = r.es.Scroll(resp.ScrollID, checkScrollTime); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	return report, nil
+}
+
+// checkChunk checks the documents of the rows after the last primary key and
+// returns the number of rows read and the primary key of the last one.
+func (r *River) checkChunk(rule *Rule, last []interface{}, limit int, repair bool, report *CheckReport) (int, []interface{}, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+
+	t := rule.TableInfo
+
+	res, err := r.getCanal().Execute(backfillQuery(t, last, limit))
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+
+	rows := res.Values
+	if len(rows) == 0 {
+		return 0, nil, nil
+	}
+
+	report.Rows += uint64(len(rows))
+
+	diffs, err := r.checkRows(rule, rows)
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+
+	if len(diffs) > 0 {
+		if err = r.sleep(checkRecheckDelay); err != nil {
+			return 0, nil, errors.Trace(err)
+		}
+
+		// Rows deleted in the meantime are not read again.
+		if res, err = r.getCanal().Execute(keysQuery(t, tableColumns(t), diffKeys(diffs))); err != nil {
+			return 0, nil, errors.Trace(err)
+		}
+
+		if diffs, err = r.checkRows(rule, res.Values); err != nil {
+			return 0, nil, errors.Trace(err)
+		}
+	}
+
+	for _, diff := range diffs {
+		if diff.missing {
+			report.addMissing(diff.id)
+		} else {
+			report.addDifferent(diff.id)
+		}
+	}
+
+	if repair && len(diffs) > 0 {
+		n, err := r.repairRows(rule, diffKeys(diffs))
+		if err != nil {
+			return 0, nil, errors.Trace(err)
+		}
+
+		report.Repaired += uint64(n)
+	}
+
+	return len(rows), rowKey(t, rows[len(rows)-1]), nil
+}
+
+// checkRows returns the rows whose documents are missing or different.
+func (r *River) checkRows(rule *Rule, rows [][]interface{}) ([]*rowDiff, error) {
+	if len(rule.SoftDelete) > 0 || rule.where != nil {
+		// Skip the rows with no document.
+		kept := make([][]interface{}, 0, len(rows))
+		for _, row := range rows {
+			if rule.indexed(backfillValues(rule.TableInfo, row)) {
+				kept = append(kept, row)
+			}
+		}
+		rows = kept
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	reqs, err := r.makeIndexRequests(rule, rows)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	mdocs := make([]*elastic.MGetDoc, 0, len(reqs))
+	for _, req := range reqs {
+		mdocs = append(mdocs, &elastic.MGetDoc{Index: req.Index, ID: req.ID, Routing: req.Routing})
+	}
+
+	resp, err := r.es.MGet("", mdocs)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	docs := make(map[string]map[string]interface{}, len(resp.Docs))
+	for _, doc := range resp.Docs {
+		if doc.Found {
+			docs[doc.ID] = doc.Source
+		}
+	}
+
+	var diffs []*rowDiff
+	for i, req := range reqs {
+		source, ok := docs[req.ID]
+		if !ok {
+			diffs = append(diffs, &rowDiff{rowKey(rule.TableInfo, rows[i]), req.ID, true})
+			continue
+		}
+
+		// An ingest pipeline may change the document.
+		if len(rule.Pipeline) > 0 {
+			continue
+		}
+
+		// The document of a parent keeping its nested fields is the upsert.
+		data := req.Data
+		if data == nil {
+			data = req.Upsert
+		}
+
+		same, err := sameDoc(data, source)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		if !same {
+			diffs = append(diffs, &rowDiff{rowKey(rule.TableInfo, rows[i]), req.ID, false})
+		}
+	}
+
+	return diffs, nil
+}
+
+// repairRows reads the rows again and sends their documents to ES.
+func (r *River) repairRows(rule *Rule, keys [][]interface{}) (int, error) {
+	r.rowsLock.Lock()
+	defer r.rowsLock.Unlock()
+
+	t := rule.TableInfo
+
+	res, err := r.getCanal().Execute(keysQuery(t, tableColumns(t), keys))
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	reqs, err := r.makeIndexRequests(rule, res.Values)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	setVersions(rule, reqs, r.getCanal().SyncedPosition())
+
+	return len(reqs), errors.Trace(r.queueRequests(reqs))
+}
+
+// checkDocs finds the documents with no row.
+func (r *River) checkDocs(rule *Rule, docs []*elastic.ResponseItem, repair bool, report *CheckReport) error {
+	if err := r.ctx.Err(); err != nil {
+		return errors.Trace(err)
+	}
+
+	report.Docs += uint64(len(docs))
+
+	ids := make([]string, 0, len(docs))
+	found := make(map[string]*elastic.ResponseItem, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+		found[doc.ID] = doc
+	}
+
+	extra, err := r.missingRows(rule, ids)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if len(extra) > 0 {
+		if err = r.sleep(checkRecheckDelay); err != nil {
+			return errors.Trace(err)
+		}
+
+		if extra, err = r.missingRows(rule, extra); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if len(extra) > 0 {
+		// Skip the documents deleted in the meantime.
+		mdocs := make([]*elastic.MGetDoc, 0, len(extra))
+		for _, id := range extra {
+			mdocs = append(mdocs, &elastic.MGetDoc{Index: found[id].Index, ID: id, Routing: found[id].Routing})
+		}
+
+		resp, err := r.es.MGet("", mdocs)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		extra = extra[:0]
+		for _, doc := range resp.Docs {
+			if doc.Found {
+				extra = append(extra, doc.ID)
+			}
+		}
+	}
+
+	for _, id := range extra {
+		report.addExtra(id)
+	}
+
+	if repair && len(extra) > 0 {
+		n, err := r.repairDocs(rule, extra, found)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		report.Repaired += uint64(n)
+	}
+
+	return nil
+}
+
+// repairDocs deletes the documents if their rows still do not exist,
+// docs holds the index and routing of the documents by ID.
+func (r *River) repairDocs(rule *Rule, ids []string, docs map[string]*elastic.ResponseItem) (int, error) {
+	r.rowsLock.Lock()
+	defer r.rowsLock.Unlock()
+
+	extra, err := r.missingRows(rule, ids)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	reqs := make([]*sink.Event, 0, len(extra))
+	for _, id := range extra {
+		req := newEvent(rule, id, nil)
+		req.Action = sink.ActionDelete
+		req.Index = docs[id].Index
+		req.Routing = docs[id].Routing
+		reqs = append(reqs, req)
+	}
+
+	setVersions(rule, reqs, r.getCanal().SyncedPosition())
+
+	return len(reqs), errors.Trace(r.queueRequests(reqs))
+}
+
+// missingRows returns the document IDs with no row in the table.
+func (r *River) missingRows(rule *Rule, ids []string) ([]string, error) {
+	t := rule.TableInfo
+
+	keys := make([][]interface{}, 0, len(ids))
+	for _, id := range ids {
+		if key := docKey(t, id); key != nil {
+			keys = append(keys, key)
+		}
+	}
+
+	found := make(map[string]struct{}, len(keys))
+	if len(keys) > 0 {
+		res, err := r.getCanal().Execute(keysQuery(t, pkColumns(t), keys))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		for _, key := range res.Values {
+			found[keyID(t, key)] = struct{}{}
+		}
+	}
+
+	var missing []string
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	return missing, nil
+}
+
+// canCheckExtra returns true if the rows of the documents in the rule's index
+// can be found: the document ID is the primary key and no other rule writes
+// to the index.
+func (r *River) canCheckExtra(rule *Rule) bool {
+	if rule.ID != nil {
+		return false
+	}
+
+	r.rulesLock.RLock()
+	defer r.rulesLock.RUnlock()
+
+	for _, o := range r.rules {
+		if o != rule && o.indexPattern() == rule.indexPattern() && len(o.Nested) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// randomKey returns the primary key of a random row, nil if the table is empty.
+func (r *River) randomKey(rule *Rule) ([]interface{}, error) {
+	t := rule.TableInfo
+
+	res, err := r.getCanal().Execute(fmt.Sprintf(
+		"SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = '%s' AND TABLE_NAME = '%s'",
+		mysql.Escape(t.Schema), mysql.Escape(t.Name)))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	rows, _ := res.GetUint(0, 0)
+	if rows == 0 {
+		return nil, nil
+	}
+
+	res, err = r.getCanal().Execute(fmt.Sprintf("SELECT %s FROM %s.%s ORDER BY %s LIMIT %d, 1",
+		pkColumns(t), quoteName(t.Schema), quoteName(t.Name), pkColumns(t), rand.Int63n(int64(rows))))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if len(res.Values) == 0 {
+		return nil, nil
+	}
+
+	return res.Values[0], nil
+}
+
+func (r *River) sleep(d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-r.ctx.Done():
+		return errors.Trace(r.ctx.Err())
+	}
+}
+
+func (r *River) checkLoop() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.c.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.ctx.Done():
+			return
+		}
+
+		select {
+		case <-r.WaitDumpDone():
+		default:
+			continue
+		}
+
+		reports, err := r.Check(CheckOptions{SampleChunks: r.c.CheckSampleChunks, Repair: r.c.CheckRepair})
+		if err != nil {
+			log.Errorf("check ES documents err %v", err)
+			continue
+		}
+
+		for _, report := range reports {
+			if !report.Consistent() {
+				log.Warnf("inconsistent ES documents for %s, missing: %d, extra: %d, different: %d, repaired: %d",
+					report.Rule, report.Missing, report.Extra, report.Different, report.Repaired)
+			}
+		}
+	}
+}
+
+// sameDoc checks whether the source has the same values as the document data
+// sent to ES.
+func sameDoc(data map[string]interface{}, source map[string]interface{}) (bool, error) {
+	// Compare the data as ES returns it.
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+
+	var expected map[string]interface{}
+	if err = json.Unmarshal(buf, &expected); err != nil {
+		return false, errors.Trace(err)
+	}
+
+	for k, v := range expected {
+		if !reflect.DeepEqual(v, source[k]) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func diffKeys(diffs []*rowDiff) [][]interface{} {
+	keys := make([][]interface{}, 0, len(diffs))
+	for _, diff := range diffs {
+		keys = append(keys, diff.key)
+	}
+
+	return keys
+}
+
+// docKey returns the primary key of the document ID made by getDocID,
+// nil if the ID does not match the primary key.
+func docKey(t *schema.Table, id string) []interface{} {
+	parts := strings.Split(id, ":")
+	if len(parts) != len(t.PKColumns) {
+		return nil
+	}
+
+	key := make([]interface{}, len(parts))
+	for i, p := range parts {
+		key[i] = p
+	}
+
+	return key
+}
+
+// keyID returns the document ID of the primary key read with the text protocol.
+func keyID(t *schema.Table, key []interface{}) string {
+	parts := make([]string, 0, len(key))
+	for i, v := range key {
+		parts = append(parts, fmt.Sprintf("%v", backfillValue(&t.Columns[t.PKColumns[i]], v)))
+	}
+
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,71 @@
+package river
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fasttrack-solutions/go-mysql/schema"
+)
+
+func TestSameDoc(t *testing.T) {
+	data := map[string]interface{}{
+		"id":    int64(1),
+		"title": "title",
+		"tags":  []string{"a", "b"},
+		"score": 1.5,
+		"empty": nil,
+	}
+
+	tests := []struct {
+		Source map[string]interface{}
+		Expect bool
+	}{
+		{map[string]interface{}{"id": float64(1), "title": "title", "tags": []interface{}{"a", "b"}, "score": 1.5, "empty": nil}, true},
+		// Fields not synced by the rule are ignored.
+		{map[string]interface{}{"id": float64(1), "title": "title", "tags": []interface{}{"a", "b"}, "score": 1.5, "other": 1}, true},
+		{map[string]interface{}{"id": float64(1), "title": "other", "tags": []interface{}{"a", "b"}, "score": 1.5}, false},
+		{map[string]interface{}{"id": float64(1), "title": "title", "tags": []interface{}{"a"}, "score": 1.5}, false},
+		{map[string]interface{}{"id": float64(1), "title": "title", "tags": []interface{}{"a", "b"}}, false},
+	}
+
+	for _, test := range tests {
+		same, err := sameDoc(data, test.Source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if same != test.Expect {
+			t.Errorf("Source: %v, Expected: is %t, but: was %t", test.Source, test.Expect, same)
+		}
+	}
+}
+
+func TestDocKey(t *testing.T) {
+	table := &schema.Table{
+		Schema: "test",
+		Name:   "test_river",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "tenant", Type: schema.TYPE_STRING},
+		},
+		PKColumns: []int{1, 0},
+	}
+
+	if key := docKey(table, "a:1"); !reflect.DeepEqual(key, []interface{}{"a", "1"}) {
+		t.Errorf("Expected: is [a 1], but: was %v", key)
+	}
+
+	if key := docKey(table, "1"); key != nil {
+		t.Errorf("Expected: is nil, but: was %v", key)
+	}
+
+	if id := keyID(table, []interface{}{[]byte("a"), []byte("1")}); id != "a:1" {
+		t.Errorf("Expected: is a:1, but: was %s", id)
+	}
+
+	query := keysQuery(table, pkColumns(table), [][]interface{}{{"a", "1"}, {"b", "2"}})
+	expect := "SELECT `tenant`, `id` FROM `test`.`test_river` WHERE (`tenant`, `id`) IN (('a', '1'), ('b', '2'))"
+	if query != expect {
+		t.Errorf("Expected: is %s, but: was %s", expect, query)
+	}
+}
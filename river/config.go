@@ -14,10 +14,41 @@ type SourceConfig struct {
 	Tables []string `toml:"tables"`
 }
 
+// Sink types.
+const (
+	sinkTypeElasticsearch = "elasticsearch"
+	sinkTypeOpenSearch    = "opensearch"
+	sinkTypeFile          = "file"
+	sinkTypeStdout        = "stdout"
+	sinkTypeKafka         = "kafka"
+)
+
+// SinkConfig is the config of an output rules can write to.
+type SinkConfig struct {
+	Name string `toml:"name"`
+	Type string `toml:"type"`
+
+	// For elasticsearch and opensearch.
+	Addr     string `toml:"addr"`
+	User     string `toml:"user"`
+	Password string `toml:"password"`
+	HTTPS    bool   `toml:"https"`
+
+	// For file.
+	Path string `toml:"path"`
+
+	// For kafka, the topic of a rule is the prefix and the rule's index.
+	Brokers     []string `toml:"brokers"`
+	TopicPrefix string   `toml:"topic_prefix"`
+}
+
 // Config is the configuration
 type Config struct {
 	TT TimeTracker
 
+	// ConfigFile is the file the config was read from, used on reload.
+	ConfigFile string `toml:"-"`
+
 	DataStorage string
 	MappingsDir string
 
@@ -35,12 +66,22 @@ type Config struct {
 	ESAddr     string
 	ESUser     string
 	ESPassword string
+	// ESAliases creates the indices of the mappings directory behind
+	// aliases, so they can be rebuilt by Reindex.
+	ESAliases bool
+	// AllowIncompatibleMappings starts even if a changed mapping file can
+	// not be applied to the existing index.
+	AllowIncompatibleMappings bool
+	// MappingCheck is the policy of the check of the rules against the
+	// mappings of the existing indices on start: off, warn or fail.
+	MappingCheck string
 
 	StatAddr string
 
 	ServerID uint32
 	Flavor   string
 	DataDir  string
+	UseGTID  bool
 
 	DumpExec       string
 	SkipMasterData bool
@@ -49,11 +90,39 @@ type Config struct {
 
 	Rules []*Rule `toml:"rule"`
 
+	Sinks []SinkConfig `toml:"sink"`
+
 	BulkSize int
 
 	FlushBulkTime time.Duration
 
+	BulkRetries         int
+	BulkRetryBackoff    time.Duration
+	BulkRetryMaxBackoff time.Duration
+
 	SkipNoPkTable bool
+
+	BackfillChunkSize int
+	BackfillNewTables bool
+
+	CheckInterval     time.Duration
+	CheckSampleChunks int
+	CheckRepair       bool
+
+	// HashSalt is prepended to the values of the fields with the hash modifier.
+	HashSalt string `toml:"hash_salt"`
+}
+
+// HasStdoutSink checks whether a sink writes the events to stdout, the logs
+// must then be written elsewhere.
+func (c *Config) HasStdoutSink() bool {
+	for _, sc := range c.Sinks {
+		if sc.Type == sinkTypeStdout {
+			return true
+		}
+	}
+
+	return false
 }
 
 // NewConfigWithFile creates a Config from file.
@@ -63,7 +132,14 @@ func NewConfigWithFile(name string) (*Config, error) {
 		return nil, errors.Trace(err)
 	}
 
-	return NewConfig(string(data))
+	c, err := NewConfig(string(data))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	c.ConfigFile = name
+
+	return c, nil
 }
 
 // NewConfig creates a Config from data.
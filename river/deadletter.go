@@ -0,0 +1,330 @@
+package river
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/fasttrack-solutions/go-mysql/mysql"
+	"github.com/go-redis/redis"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/go/ioutil2"
+)
+
+const (
+	redisDeadLetterKey string = "go-mysql-elasticsearch-dead-letter"
+
+	deadLetterFileName string = "dead_letter.jsonl"
+)
+
+// DeadLetter is an event a sink permanently rejected.
+type DeadLetter struct {
+	ID      string          `json:"id"`
+	Time    time.Time       `json:"time"`
+	Rule    string          `json:"rule"`
+	BinName string          `json:"bin_name"`
+	BinPos  uint32          `json:"bin_pos"`
+	Status  int             `json:"status"`
+	Error   json.RawMessage `json:"error"`
+	Request *sink.Event     `json:"request"`
+
+	// raw is the stored JSON line, used to remove the record from Redis.
+	raw string
+}
+
+// deadLetterQueue stores dead letters in the same data storage as master info.
+type deadLetterQueue struct {
+	redisClient *redis.Client
+	redisKey    string
+	mode        string
+
+	sync.Mutex
+
+	filePath string
+	seq      uint64
+}
+
+func newDeadLetterQueue(c *Config) (*deadLetterQueue, error) {
+	q := new(deadLetterQueue)
+
+	q.mode = c.DataStorage
+
+	switch c.DataStorage {
+	case redisStorageMore:
+		q.redisClient = redis.NewClient(&redis.Options{
+			Addr:     c.RedisAddr,
+			Password: c.RedisPassword,
+			DB:       int(c.RedisDB),
+		})
+
+		_, pingErr := q.redisClient.Ping().Result()
+		if pingErr != nil {
+			return nil, pingErr
+		}
+
+		q.redisKey = redisDeadLetterKey + c.RedisKeyPostfix
+
+	case fsStorageMore:
+		if len(c.DataDir) > 0 {
+			q.filePath = path.Join(c.DataDir, deadLetterFileName)
+		}
+
+		if mkdirErr := os.MkdirAll(c.DataDir, 0755); mkdirErr != nil {
+			return nil, errors.Trace(mkdirErr)
+		}
+
+	default:
+		return nil, fmt.Errorf("Invalid data storage value received [%s], accepted: %s, %s", c.DataStorage, redisStorageMore, fsStorageMore)
+	}
+
+	return q, nil
+}
+
+// Add appends the dead letters to the queue.
+func (q *deadLetterQueue) Add(dls ...*DeadLetter) error {
+	if len(dls) == 0 {
+		return nil
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	lines := make([]interface{}, 0, len(dls))
+	var buf bytes.Buffer
+	for _, dl := range dls {
+		q.seq++
+		dl.ID = fmt.Sprintf("%d-%d", dl.Time.UnixNano(), q.seq)
+
+		data, err := json.Marshal(dl)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		lines = append(lines, string(data))
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	switch q.mode {
+	case redisStorageMore:
+		return errors.Trace(q.redisClient.RPush(q.redisKey, lines...).Err())
+
+	case fsStorageMore:
+		if len(q.filePath) == 0 {
+			return nil
+		}
+
+		f, err := os.OpenFile(q.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer f.Close()
+
+		_, err = f.Write(buf.Bytes())
+		return errors.Trace(err)
+	}
+
+	return nil
+}
+
+// List returns all dead letters in the order they were added.
+func (q *deadLetterQueue) List() ([]*DeadLetter, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.list()
+}
+
+func (q *deadLetterQueue) list() ([]*DeadLetter, error) {
+	var lines []string
+
+	switch q.mode {
+	case redisStorageMore:
+		var err error
+		lines, err = q.redisClient.LRange(q.redisKey, 0, -1).Result()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+	case fsStorageMore:
+		if len(q.filePath) == 0 {
+			return nil, nil
+		}
+
+		f, err := os.Open(q.filePath)
+		if os.IsNotExist(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, errors.Trace(err)
+		}
+		defer f.Close()
+
+		s := bufio.NewScanner(f)
+		s.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for s.Scan() {
+			if len(s.Bytes()) > 0 {
+				lines = append(lines, s.Text())
+			}
+		}
+
+		if err = s.Err(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	dls := make([]*DeadLetter, 0, len(lines))
+	for _, line := range lines {
+		dl := new(DeadLetter)
+		if err := json.Unmarshal([]byte(line), dl); err != nil {
+			log.Errorf("skip invalid dead letter %s, err %v", line, err)
+			continue
+		}
+
+		dl.raw = line
+		dls = append(dls, dl)
+	}
+
+	return dls, nil
+}
+
+// Remove deletes the dead letters from the queue.
+func (q *deadLetterQueue) Remove(dls []*DeadLetter) error {
+	if len(dls) == 0 {
+		return nil
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	switch q.mode {
+	case redisStorageMore:
+		for _, dl := range dls {
+			if err := q.redisClient.LRem(q.redisKey, 1, dl.raw).Err(); err != nil {
+				return errors.Trace(err)
+			}
+		}
+
+	case fsStorageMore:
+		if len(q.filePath) == 0 {
+			return nil
+		}
+
+		removed := make(map[string]struct{}, len(dls))
+		for _, dl := range dls {
+			removed[dl.ID] = struct{}{}
+		}
+
+		current, err := q.list()
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		var buf bytes.Buffer
+		for _, dl := range current {
+			if _, ok := removed[dl.ID]; ok {
+				continue
+			}
+
+			buf.WriteString(dl.raw)
+			buf.WriteByte('\n')
+		}
+
+		if err = ioutil2.WriteFileAtomic(q.filePath, buf.Bytes(), 0644); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	return nil
+}
+
+func (q *deadLetterQueue) Close() error {
+	if q.redisClient != nil {
+		return q.redisClient.Close()
+	}
+
+	return nil
+}
+
+func newDeadLetter(req *sink.Event, pos mysql.Position, status int, reason json.RawMessage) *DeadLetter {
+	return &DeadLetter{
+		Time:    time.Now(),
+		Rule:    req.Rule,
+		BinName: pos.Name,
+		BinPos:  pos.Pos,
+		Status:  status,
+		Error:   reason,
+		Request: req,
+	}
+}
+
+// DeadLetters returns the bulk requests Elasticsearch permanently rejected.
+func (r *River) DeadLetters() ([]*DeadLetter, error) {
+	return r.deadLetters.List()
+}
+
+// ReplayDeadLetters sends the dead letters with the given ids to Elasticsearch
+// again, all of them if no ids are given. They are queued after the row events
+// like backfilled rows and written to the indices being rebuilt too. Replayed
+// dead letters are removed from the queue once sent, the ones rejected again
+// are added back as new dead letters.
+func (r *River) ReplayDeadLetters(ids []string) (int, error) {
+	dls, err := r.deadLetters.List()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	if len(ids) > 0 {
+		wanted := make(map[string]struct{}, len(ids))
+		for _, id := range ids {
+			wanted[id] = struct{}{}
+		}
+
+		selected := dls[:0]
+		for _, dl := range dls {
+			if _, ok := wanted[dl.ID]; ok {
+				selected = append(selected, dl)
+			}
+		}
+		dls = selected
+	}
+
+	if len(dls) == 0 {
+		return 0, nil
+	}
+
+	reqs := make([]*sink.Event, 0, len(dls))
+	for _, dl := range dls {
+		reqs = append(reqs, dl.Request)
+	}
+
+	log.Infof("replay %d dead letters", len(reqs))
+
+	if err = r.queueReplay(reqs); err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	if err = r.flush(); err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	if err = r.deadLetters.Remove(dls); err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	return len(dls), nil
+}
+
+// queueReplay queues the replayed requests in order with the row events.
+func (r *River) queueReplay(reqs []*sink.Event) error {
+	r.rowsLock.Lock()
+	defer r.rowsLock.Unlock()
+
+	return errors.Trace(r.queueRequests(reqs))
+}
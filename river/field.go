@@ -0,0 +1,297 @@
+package river
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fasttrack-solutions/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+// Field modifiers hiding the value of a column, set in [rule.field] like
+// email = ",hash" or phone = ",mask:4".
+const (
+	// fieldTypeHash is the hex SHA-256 of the hash salt and the value.
+	fieldTypeHash = "hash"
+	// fieldTypeMask replaces all but the last n characters, 4 by default, with *.
+	fieldTypeMask = "mask"
+	// fieldTypeTruncate keeps the first n characters.
+	fieldTypeTruncate = "truncate"
+)
+
+// Field modifiers converting the value of a column.
+const (
+	// fieldTypeJSON decodes a JSON string.
+	fieldTypeJSON = "json"
+	// fieldTypeGeoPoint makes a geo_point of a "lat,lon" string, or of the
+	// latitude column and the longitude column given as argument.
+	fieldTypeGeoPoint = "geo_point"
+	// fieldTypeBool makes a boolean of a TINYINT.
+	fieldTypeBool = "bool"
+	// fieldTypeString keeps a DECIMAL as string, fieldTypeFloat converts it to a number.
+	fieldTypeString = "string"
+	fieldTypeFloat  = "float"
+	// fieldTypeDateMs converts a unix time in milliseconds to a date.
+	fieldTypeDateMs = "date_ms"
+	// fieldTypeDatetime converts a DATETIME in the time zone given as argument to a date.
+	fieldTypeDatetime = "datetime"
+)
+
+const defaultMaskKeep = 4
+
+// dateMsFormat is RFC3339 with milliseconds.
+const dateMsFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// locations caches the time zones of the datetime modifiers.
+var locations sync.Map
+
+// splitFieldType splits the field modifier into its name and argument,
+// e.g. "mask:4" into "mask" and "4".
+func splitFieldType(fieldType string) (string, string) {
+	parts := strings.SplitN(fieldType, ":", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+
+	return parts[0], ""
+}
+
+// checkFieldType checks the argument of the field modifier.
+func checkFieldType(fieldType string) error {
+	name, arg := splitFieldType(fieldType)
+
+	switch name {
+	case fieldTypeMask:
+		if len(arg) == 0 {
+			return nil
+		}
+		fallthrough
+	case fieldTypeTruncate:
+		if n, err := strconv.Atoi(arg); err != nil || n < 0 {
+			return errors.Errorf("invalid %s length %q", name, arg)
+		}
+	case fieldTypeDatetime:
+		if _, err := loadLocation(arg); err != nil {
+			return errors.Errorf("invalid %s time zone %q", name, arg)
+		}
+	}
+
+	return nil
+}
+
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	locations.Store(name, loc)
+
+	return loc, nil
+}
+
+// fieldString returns the converted column value as string.
+func fieldString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	}
+
+	return fmt.Sprintf("%v", v), true
+}
+
+// listField splits the string by sep.
+func listField(v interface{}, sep string) interface{} {
+	if str, ok := v.(string); ok {
+		return strings.Split(str, sep)
+	}
+
+	return v
+}
+
+// jsonField decodes the JSON string, the string is kept if it is not valid.
+func jsonField(v interface{}) interface{} {
+	s, ok := fieldString(v)
+	if !ok {
+		return v
+	}
+
+	var f interface{}
+	if err := json.Unmarshal([]byte(s), &f); err != nil {
+		return v
+	}
+
+	return f
+}
+
+// geoPointField makes a geo_point of the latitude and longitude, or of a
+// "lat,lon" string if lon is nil. nil is returned for invalid values.
+func geoPointField(lat interface{}, lon interface{}) interface{} {
+	latStr, ok := fieldString(lat)
+	if !ok {
+		return nil
+	}
+
+	var lonStr string
+	if lon == nil {
+		parts := strings.Split(latStr, ",")
+		if len(parts) != 2 {
+			return nil
+		}
+		latStr, lonStr = parts[0], parts[1]
+	} else {
+		lonStr, _ = fieldString(lon)
+	}
+
+	la, err := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	if err != nil {
+		return nil
+	}
+
+	lo, err := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	if err != nil {
+		return nil
+	}
+
+	return map[string]interface{}{"lat": la, "lon": lo}
+}
+
+// boolField makes a boolean of a number, false for 0.
+func boolField(v interface{}) interface{} {
+	s, ok := fieldString(v)
+	if !ok {
+		return nil
+	}
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f != 0
+	}
+
+	if b, err := strconv.ParseBool(s); err == nil {
+		return b
+	}
+
+	return v
+}
+
+// stringField formats the value as string.
+func stringField(v interface{}) interface{} {
+	if s, ok := fieldString(v); ok {
+		return s
+	}
+
+	return nil
+}
+
+// floatField converts the value to a float, it is kept if it is no number.
+func floatField(v interface{}) interface{} {
+	s, ok := fieldString(v)
+	if !ok {
+		return nil
+	}
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+
+	return v
+}
+
+// dateMsField converts the unix time in milliseconds to a date.
+func dateMsField(v interface{}) interface{} {
+	s, ok := fieldString(v)
+	if !ok {
+		return nil
+	}
+
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return v
+	}
+
+	return time.Unix(ms/1000, ms%1000*int64(time.Millisecond)).Format(dateMsFormat)
+}
+
+// datetimeField converts the DATETIME in the time zone to a date, nil is
+// returned for a zero or invalid DATETIME.
+func datetimeField(v interface{}, zone string) interface{} {
+	s, ok := fieldString(v)
+	if !ok {
+		return nil
+	}
+
+	loc, err := loadLocation(zone)
+	if err != nil {
+		return v
+	}
+
+	t, err := time.ParseInLocation(mysql.TimeFormat, s, loc)
+	if err != nil || t.IsZero() {
+		return nil
+	}
+
+	return t.Format(time.RFC3339Nano)
+}
+
+// isTransform checks whether the field modifier hides the value.
+func isTransform(fieldType string) bool {
+	switch name, _ := splitFieldType(fieldType); name {
+	case fieldTypeHash, fieldTypeMask, fieldTypeTruncate:
+		return true
+	}
+
+	return false
+}
+
+// transformValue hashes, masks or truncates the converted column value,
+// NULL is kept.
+func transformValue(fieldType string, salt string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		s = fmt.Sprintf("%v", v)
+	}
+
+	name, arg := splitFieldType(fieldType)
+	switch name {
+	case fieldTypeHash:
+		sum := sha256.Sum256([]byte(salt + s))
+		return hex.EncodeToString(sum[:])
+	case fieldTypeMask:
+		keep := defaultMaskKeep
+		if len(arg) > 0 {
+			keep, _ = strconv.Atoi(arg)
+		}
+
+		runes := []rune(s)
+		for i := 0; i < len(runes)-keep; i++ {
+			runes[i] = '*'
+		}
+		return string(runes)
+	case fieldTypeTruncate:
+		n, _ := strconv.Atoi(arg)
+		if runes := []rune(s); len(runes) > n {
+			return string(runes[:n])
+		}
+		return s
+	}
+
+	return v
+}
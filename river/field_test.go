@@ -0,0 +1,157 @@
+package river
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fasttrack-solutions/go-mysql/schema"
+	"github.com/shopspring/decimal"
+)
+
+func TestTransformValue(t *testing.T) {
+	tests := []struct {
+		FieldType string
+		Value     interface{}
+		Expect    interface{}
+	}{
+		// echo -n 'saltjohn@example.com' | sha256sum
+		{"hash", "john@example.com", "84275df39f6d1786a47398ad2d1fd49333063ed7a398902205210d4f068cfd2c"},
+		{"mask", "+4712345678", "*******5678"},
+		{"mask:2", "secret", "****et"},
+		{"mask:0", "secret", "******"},
+		{"mask", "abc", "abc"},
+		{"mask", int64(1234567), "***4567"},
+		{"truncate:3", "héllo", "hél"},
+		{"truncate:10", "hello", "hello"},
+		{"hash", nil, nil},
+	}
+
+	for _, test := range tests {
+		if v := transformValue(test.FieldType, "salt", test.Value); v != test.Expect {
+			t.Errorf("FieldType: %s, Value: %v, Expected: is %v, but: was %v", test.FieldType, test.Value, test.Expect, v)
+		}
+	}
+}
+
+func TestCheckFieldType(t *testing.T) {
+	tests := []struct {
+		FieldType string
+		Valid     bool
+	}{
+		{"list", true},
+		{"hash", true},
+		{"mask", true},
+		{"mask:4", true},
+		{"mask:x", false},
+		{"truncate:10", true},
+		{"truncate", false},
+		{"truncate:-1", false},
+		{"datetime:Europe/Riga", true},
+		{"datetime:Mars/Olympus", false},
+	}
+
+	for _, test := range tests {
+		if err := checkFieldType(test.FieldType); (err == nil) != test.Valid {
+			t.Errorf("FieldType: %s, Expected: valid %v, but: was %v", test.FieldType, test.Valid, err)
+		}
+	}
+}
+
+func TestFieldConversions(t *testing.T) {
+	geo := map[string]interface{}{"lat": 56.95, "lon": 24.1}
+
+	tests := []struct {
+		Name   string
+		Value  interface{}
+		Expect interface{}
+	}{
+		{"list", listField("a|b", "|"), []string{"a", "b"}},
+		{"list number", listField(int64(1), "|"), int64(1)},
+		{"json", jsonField(`{"a":[1,"b"]}`), map[string]interface{}{"a": []interface{}{float64(1), "b"}}},
+		{"json bytes", jsonField([]byte(`[1]`)), []interface{}{float64(1)}},
+		{"json invalid", jsonField("{a"), "{a"},
+		{"geo_point string", geoPointField("56.95, 24.1", nil), geo},
+		{"geo_point pair", geoPointField(56.95, []byte("24.1")), geo},
+		{"geo_point invalid", geoPointField("56.95", nil), nil},
+		{"geo_point null", geoPointField(nil, 24.1), nil},
+		{"bool 1", boolField(int8(1)), true},
+		{"bool 0", boolField(int64(0)), false},
+		{"bool string", boolField("true"), true},
+		{"bool null", boolField(nil), nil},
+		{"string decimal", stringField(decimal.New(1050, -2)), "10.5"},
+		{"string long decimal", stringField(decimal.New(123456789012345678, -8)), "1234567890.12345678"},
+		{"string number", stringField(int64(7)), "7"},
+		{"float", floatField("10.50"), 10.5},
+		{"float invalid", floatField("x"), "x"},
+		{"date_ms", dateMsField(int64(1559894950123)), time.Unix(1559894950, 123000000).Format(dateMsFormat)},
+		{"datetime", datetimeField("2019-06-07 10:09:10", "Europe/Riga"), "2019-06-07T10:09:10+03:00"},
+		{"datetime fraction", datetimeField("2019-01-07 10:09:10.5", "Europe/Riga"), "2019-01-07T10:09:10.5+02:00"},
+		{"datetime zero", datetimeField("0000-00-00 00:00:00", "UTC"), nil},
+	}
+
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.Value, test.Expect) {
+			t.Errorf("%s, Expected: is %#v, but: was %#v", test.Name, test.Expect, test.Value)
+		}
+	}
+}
+
+func TestGetRowFieldValue(t *testing.T) {
+	r := &River{st: &stat{}}
+
+	rule := newDefaultRule("test", "shop")
+	rule.FieldMapping = map[string]string{
+		"lat":    "location,geo_point:lon",
+		"tags":   ",list:;",
+		"price":  ",string",
+		"active": ",bool",
+	}
+	rule.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   "shop",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "lat", Type: schema.TYPE_FLOAT},
+			{Name: "lon", Type: schema.TYPE_FLOAT},
+			{Name: "tags", Type: schema.TYPE_STRING},
+			{Name: "price", Type: schema.TYPE_DECIMAL},
+			{Name: "active", Type: schema.TYPE_NUMBER},
+		},
+		PKColumns: []int{0},
+	}
+
+	before := []interface{}{int64(1), 56.95, 24.1, "a;b", decimal.New(1050, -2), int8(1)}
+	after := []interface{}{int64(1), 56.95, 24.2, "a;b", decimal.New(1050, -2), int8(0)}
+
+	reqs, err := r.makeInsertRequest(rule, [][]interface{}{before})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"id":       int64(1),
+		"location": map[string]interface{}{"lat": 56.95, "lon": 24.1},
+		"lon":      24.1,
+		"tags":     []string{"a", "b"},
+		"price":    "10.5",
+		"active":   true,
+	}
+	if !reflect.DeepEqual(reqs[0].Data, expect) {
+		t.Errorf("Expected: is %v, but: was %v", expect, reqs[0].Data)
+	}
+
+	reqs, err = r.makeUpdateRequest(rule, [][]interface{}{before, after})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect = map[string]interface{}{
+		"location": map[string]interface{}{"lat": 56.95, "lon": 24.2},
+		"lon":      24.2,
+		"active":   false,
+	}
+	if !reflect.DeepEqual(reqs[0].Data, expect) {
+		t.Errorf("Expected: is %v, but: was %v", expect, reqs[0].Data)
+	}
+}
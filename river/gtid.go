@@ -0,0 +1,191 @@
+package river
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fasttrack-solutions/go-mysql/canal"
+	"github.com/fasttrack-solutions/go-mysql/mysql"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+)
+
+// gtidSeedInterval is how often a river started from a binlog position checks
+// whether it caught up with the master to seed its GTID set.
+const gtidSeedInterval = 10 * time.Second
+
+// gtidMaster reads the state of the master, it is implemented by canal.
+type gtidMaster interface {
+	GetMasterPos() (mysql.Position, error)
+	GetMasterGTIDSet() (mysql.GTIDSet, error)
+	SyncedGTIDSet() mysql.GTIDSet
+}
+
+// gtidTracker keeps the GTID set of a river started from a binlog position,
+// canal only keeps the set if it is started from one.
+type gtidTracker struct {
+	sync.Mutex
+
+	// gset is the synced GTID set, nil until it is seeded.
+	gset mysql.GTIDSet
+	// last is the GTID of the transaction being synced.
+	last mysql.GTIDSet
+	// added counts the transactions started, pos is the end of the last one
+	// synced.
+	added uint64
+	pos   mysql.Position
+}
+
+// synced returns the GTID set synced by canal, or the tracked one if canal
+// has none.
+func (t *gtidTracker) synced(canalSet mysql.GTIDSet) mysql.GTIDSet {
+	t.Lock()
+	defer t.Unlock()
+
+	if canalSet != nil || t.gset == nil {
+		return canalSet
+	}
+
+	return t.gset.Clone()
+}
+
+// add adds the GTID of the transaction starting to the tracked set.
+func (t *gtidTracker) add(gtid mysql.GTIDSet) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.last = gtid
+	t.added++
+	if t.gset == nil {
+		return
+	}
+
+	if err := t.gset.Update(gtid.String()); err != nil {
+		log.Errorf("update GTID set %s with %s err %v", t.gset, gtid, err)
+	}
+}
+
+// commit records the end of the transaction synced.
+func (t *gtidTracker) commit(pos mysql.Position) {
+	t.Lock()
+	t.pos = pos
+	t.Unlock()
+}
+
+// seeded checks whether the tracked set is seeded.
+func (t *gtidTracker) seeded() bool {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.gset != nil
+}
+
+// seed sets the tracked set to the executed GTID set of the master if the
+// river synced everything the master has. The set matches the synced position
+// if the master is at it before and after the set is read, and no transaction
+// started meanwhile. The master is queried without the lock, so the events
+// are not held up.
+func (t *gtidTracker) seed(m gtidMaster) {
+	t.Lock()
+	seeded, added, pos := t.gset != nil, t.added, t.pos
+	t.Unlock()
+
+	if seeded || m.SyncedGTIDSet() != nil || len(pos.Name) == 0 {
+		return
+	}
+
+	before, err := m.GetMasterPos()
+	if err != nil {
+		log.Warnf("get master position to seed GTID set err %v", err)
+		return
+	}
+
+	gset, err := m.GetMasterGTIDSet()
+	if err != nil {
+		log.Warnf("get master GTID set err %v", err)
+		return
+	}
+
+	after, err := m.GetMasterPos()
+	if err != nil {
+		log.Warnf("get master position to seed GTID set err %v", err)
+		return
+	}
+
+	if before.Compare(pos) != 0 || after.Compare(pos) != 0 {
+		log.Infof("no GTID set yet, binlog %s is behind master %s", pos, after)
+		return
+	}
+
+	if len(gset.String()) == 0 {
+		log.Warnf("master has no executed GTIDs at binlog %s, can not seed GTID set", pos)
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.added != added || t.pos.Compare(pos) != 0 {
+		log.Infof("no GTID set yet, a transaction was synced after binlog %s", pos)
+		return
+	}
+
+	// The executed set may not hold the last transaction yet.
+	if t.last != nil {
+		if err = gset.Update(t.last.String()); err != nil {
+			log.Errorf("update GTID set %s with %s err %v", gset, t.last, err)
+			return
+		}
+	}
+
+	t.gset = gset
+	log.Infof("seeded GTID set %s at binlog %s", gset, pos)
+}
+
+// gtidSeedLoop seeds the GTID set of a river started from a binlog position,
+// outside of the binlog event handlers.
+func (r *River) gtidSeedLoop() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(gtidSeedInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.ctx.Done():
+			return
+		}
+
+		if r.gtid.seeded() {
+			return
+		}
+
+		r.gtid.seed(r.getCanal())
+	}
+}
+
+// checkGTIDMode checks that the MySQL master assigns GTIDs to every
+// transaction, MariaDB always does.
+func checkGTIDMode(cn *canal.Canal, flavor string) error {
+	if flavor == mysql.MariaDBFlavor {
+		return nil
+	}
+
+	res, err := cn.Execute("SELECT @@GLOBAL.gtid_mode")
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	mode, err := res.GetString(0, 0)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if !strings.EqualFold(mode, "ON") {
+		return errors.Errorf("useGTID requires gtid_mode ON on the master, but it is %s", mode)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package river
+
+import (
+	"testing"
+
+	"github.com/fasttrack-solutions/go-mysql/mysql"
+)
+
+func TestGTIDTracker(t *testing.T) {
+	const uuid = "de278ad0-2106-11e4-9f8e-6edd0ca20947"
+
+	gtid := func(s string) mysql.GTIDSet {
+		gset, err := mysql.ParseMysqlGTIDSet(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gset
+	}
+
+	var tracker gtidTracker
+
+	tracker.add(gtid(uuid + ":5"))
+	if gset := tracker.synced(nil); gset != nil {
+		t.Errorf("Expected: nil before seeding, but: was %s", gset)
+	}
+
+	tracker.gset = gtid(uuid + ":1-5")
+	tracker.add(gtid(uuid + ":6"))
+	tracker.add(gtid(uuid + ":7"))
+
+	if gset := tracker.synced(nil); gset == nil || !gset.Equal(gtid(uuid+":1-7")) {
+		t.Errorf("Expected: is %s:1-7, but: was %v", uuid, gset)
+	}
+
+	canalSet := gtid(uuid + ":1-9")
+	if gset := tracker.synced(canalSet); gset != canalSet {
+		t.Errorf("Expected: the canal set is used, but: was %v", gset)
+	}
+}
+
+func TestGTIDTrackerSeed(t *testing.T) {
+	const uuid = "de278ad0-2106-11e4-9f8e-6edd0ca20947"
+
+	pos := mysql.Position{Name: "mysql-bin.000001", Pos: 120}
+
+	tests := []struct {
+		Name   string
+		Master *testGTIDMaster
+		Seeded bool
+	}{
+		{"caught up", &testGTIDMaster{pos: pos, gset: uuid + ":1-5"}, true},
+		{"behind", &testGTIDMaster{pos: mysql.Position{Name: pos.Name, Pos: 240}, gset: uuid + ":1-6"}, false},
+		{"GTID mode off", &testGTIDMaster{pos: pos}, false},
+		{"transaction started meanwhile", &testGTIDMaster{pos: pos, gset: uuid + ":1-5", startTx: true}, false},
+	}
+
+	for _, test := range tests {
+		tracker := new(gtidTracker)
+		tracker.commit(pos)
+		test.Master.tracker = tracker
+
+		tracker.seed(test.Master)
+		if seeded := tracker.seeded(); seeded != test.Seeded {
+			t.Errorf("%s, Expected: seeded is %v, but: was %v", test.Name, test.Seeded, seeded)
+		}
+	}
+}
+
+// testGTIDMaster is a master at pos with the executed GTID set gset.
+type testGTIDMaster struct {
+	pos  mysql.Position
+	gset string
+	// startTx starts a transaction while the GTID set is read.
+	startTx bool
+	tracker *gtidTracker
+}
+
+func (m *testGTIDMaster) GetMasterPos() (mysql.Position, error) {
+	return m.pos, nil
+}
+
+func (m *testGTIDMaster) GetMasterGTIDSet() (mysql.GTIDSet, error) {
+	if m.startTx {
+		gtid, _ := mysql.ParseMysqlGTIDSet("de278ad0-2106-11e4-9f8e-6edd0ca20947:6")
+		m.tracker.add(gtid)
+	}
+
+	return mysql.ParseMysqlGTIDSet(m.gset)
+}
+
+func (m *testGTIDMaster) SyncedGTIDSet() mysql.GTIDSet {
+	return nil
+}
@@ -0,0 +1,169 @@
+package river
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/juju/errors"
+)
+
+// indexNull is the value of a NULL or invalid column in an index name.
+const indexNull = "null"
+
+// indexNameMaxLength is the longest index name ES allows, in bytes.
+const indexNameMaxLength = 255
+
+// indexTimeLayouts are the formats of the time columns in an index template,
+// converted like for the documents.
+var indexTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05.999999", "2006-01-02"}
+
+// indexNameReplacer replaces the characters ES does not allow in index names.
+var indexNameReplacer = strings.NewReplacer(
+	" ", "_", "\\", "_", "/", "_", "*", "_", "?", "_", "\"", "_",
+	"<", "_", ">", "_", "|", "_", ",", "_", "#", "_", ":", "_",
+)
+
+// indexTemplate is an index name made of the row's column values, e.g.
+// "events-{created_at:2006.01}" or "orders-{brand_id}".
+type indexTemplate struct {
+	parts []indexPart
+}
+
+// indexPart is a literal part of the template, or a column formatted with
+// the time layout if it is set.
+type indexPart struct {
+	literal string
+	column  string
+	layout  string
+}
+
+// parseIndexTemplate parses the index name, nil is returned if it has no
+// columns. The literal parts are lower-cased as ES only allows lower-case
+// index names.
+func parseIndexTemplate(s string) (*indexTemplate, error) {
+	if !strings.Contains(s, "{") && !strings.Contains(s, "}") {
+		return nil, nil
+	}
+
+	t := new(indexTemplate)
+	for len(s) > 0 {
+		start := strings.IndexByte(s, '{')
+		end := strings.IndexByte(s, '}')
+		if start < 0 {
+			if end >= 0 {
+				return nil, errors.Errorf("unexpected } in index %q", s)
+			}
+			t.parts = append(t.parts, indexPart{literal: strings.ToLower(s)})
+			break
+		}
+
+		if end < start {
+			return nil, errors.Errorf("unexpected } in index %q", s)
+		}
+
+		if start > 0 {
+			t.parts = append(t.parts, indexPart{literal: strings.ToLower(s[:start])})
+		}
+
+		column, layout := splitFieldType(s[start+1 : end])
+		if len(column) == 0 || strings.ContainsAny(column, "{") {
+			return nil, errors.Errorf("invalid column %q in index %q", s[start+1:end], s)
+		}
+		t.parts = append(t.parts, indexPart{column: column, layout: layout})
+
+		s = s[end+1:]
+	}
+
+	return t, nil
+}
+
+// columns returns the names of the columns used.
+func (t *indexTemplate) columns() []string {
+	var columns []string
+	for _, p := range t.parts {
+		if len(p.column) > 0 {
+			columns = append(columns, p.column)
+		}
+	}
+
+	return columns
+}
+
+// pattern returns the index pattern matching all the indices of the template.
+func (t *indexTemplate) pattern() string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if len(p.column) > 0 {
+			b.WriteByte('*')
+		} else {
+			b.WriteString(p.literal)
+		}
+	}
+
+	return b.String()
+}
+
+// name returns the index name of the row. The column values are converted
+// like for the documents, and the characters ES does not allow are replaced.
+func (t *indexTemplate) name(rule *Rule, values []interface{}) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if len(p.column) == 0 {
+			b.WriteString(p.literal)
+			continue
+		}
+
+		var v interface{}
+		if i := rule.TableInfo.FindColumn(p.column); i >= 0 && i < len(values) && values[i] != nil {
+			v = makeReqColumnData(&rule.TableInfo.Columns[i], values[i])
+		}
+
+		if v == nil {
+			b.WriteString(indexNull)
+		} else if len(p.layout) > 0 {
+			b.WriteString(strings.ToLower(indexTime(columnString(v), p.layout)))
+		} else {
+			b.WriteString(strings.ToLower(columnString(v)))
+		}
+	}
+
+	return indexName(b.String())
+}
+
+// indexName makes the name valid for ES: the forbidden characters are
+// replaced, the leading -, _ and + removed and the name cut to the longest
+// allowed.
+func indexName(name string) string {
+	name = strings.TrimLeft(indexNameReplacer.Replace(name), "-_+")
+	if len(name) > indexNameMaxLength {
+		n := indexNameMaxLength
+		for n > 0 && !utf8.RuneStart(name[n]) {
+			n--
+		}
+		name = name[:n]
+	}
+
+	if len(name) == 0 || name == "." || name == ".." {
+		return indexNull
+	}
+
+	return name
+}
+
+// indexTime formats the DATETIME, DATE or unix time in seconds with the
+// layout, in UTC for unix times.
+func indexTime(s string, layout string) string {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0).UTC().Format(layout)
+	}
+
+	for _, l := range indexTimeLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t.Format(layout)
+		}
+	}
+
+	return indexNull
+}
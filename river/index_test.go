@@ -0,0 +1,146 @@
+package river
+
+import (
+	"testing"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/fasttrack-solutions/go-mysql/schema"
+)
+
+func newIndexRule(index string) (*Rule, error) {
+	rule := newDefaultRule("test", "events")
+	rule.Index = index
+	rule.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   "events",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "brandId", Type: schema.TYPE_STRING},
+			{Name: "created_at", Type: schema.TYPE_DATETIME},
+			{Name: "ts", Type: schema.TYPE_NUMBER},
+			{Name: "kind", Type: schema.TYPE_ENUM, EnumValues: []string{"Click", "View"}},
+		},
+		PKColumns: []int{0},
+	}
+
+	return rule, rule.prepare()
+}
+
+func TestIndexTemplate(t *testing.T) {
+	row := []interface{}{int64(1), "EU", "2019-06-07 10:09:10", int64(1559894950), nil}
+
+	tests := []struct {
+		Index   string
+		Name    string
+		Pattern string
+	}{
+		{"Events", "events", "events"},
+		{"Events-{created_at:2006.01}", "events-2019.06", "events-*"},
+		{"orders-{brandId}", "orders-eu", "orders-*"},
+		{"e-{brandId}-{ts:2006.01.02}", "e-eu-2019.06.07", "e-*-*"},
+		{"e-{created_at:Jan}", "e-jun", "e-*"},
+	}
+
+	for _, test := range tests {
+		rule, err := newIndexRule(test.Index)
+		if err != nil {
+			t.Errorf("Index: %s, err: %v", test.Index, err)
+			continue
+		}
+
+		if name := rule.indexName(row); name != test.Name {
+			t.Errorf("Index: %s, Expected: is %s, but: was %s", test.Index, test.Name, name)
+		}
+
+		if pattern := rule.indexPattern(); pattern != test.Pattern {
+			t.Errorf("Index: %s, Expected: pattern is %s, but: was %s", test.Index, test.Pattern, pattern)
+		}
+	}
+
+	rule, _ := newIndexRule("events-{created_at:2006.01}")
+	for _, values := range [][]interface{}{
+		{int64(1), "EU", nil, nil, nil},
+		{int64(1), "EU", "0000-00-00 00:00:00", nil, nil},
+	} {
+		if name := rule.indexName(values); name != "events-null" {
+			t.Errorf("Values: %v, Expected: is events-null, but: was %s", values, name)
+		}
+	}
+
+	// The binlog has the index of an ENUM value.
+	rule, _ = newIndexRule("events-{kind}")
+	if name := rule.indexName([]interface{}{int64(1), "EU", nil, nil, int64(2)}); name != "events-view" {
+		t.Errorf("Expected: is events-view, but: was %s", name)
+	}
+
+	rule, _ = newIndexRule("{brandId}-events")
+	for _, test := range []struct {
+		Brand string
+		Name  string
+	}{
+		{"EU/West #1", "eu_west__1-events"},
+		{"a,b*c?", "a_b_c_-events"},
+		{"_eu", "eu-events"},
+		{"+-eu", "eu-events"},
+	} {
+		if name := rule.indexName([]interface{}{int64(1), test.Brand, nil, nil, nil}); name != test.Name {
+			t.Errorf("Brand: %s, Expected: is %s, but: was %s", test.Brand, test.Name, name)
+		}
+	}
+
+	for _, index := range []string{"events-{", "events-}", "events-{}", "events-}{id}", "events-{{id}"} {
+		if _, err := newIndexRule(index); err == nil {
+			t.Errorf("Index: %s, Expected: error, but: was nil", index)
+		}
+	}
+}
+
+func TestMakeIndexTemplateRequest(t *testing.T) {
+	r := &River{st: &stat{}}
+
+	rule, err := newIndexRule("events-{created_at:2006.01}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqs, err := r.makeUpdateRequest(rule, [][]interface{}{
+		{int64(1), "eu", "2019-06-07 10:09:10", nil, nil},
+		{int64(1), "us", "2019-06-30 10:09:10", nil, nil},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 1 || reqs[0].Action != sink.ActionUpdate || reqs[0].Index != "events-2019.06" {
+		t.Errorf("Expected: update in events-2019.06, but: was %+v", reqs)
+	}
+
+	reqs, err = r.makeUpdateRequest(rule, [][]interface{}{
+		{int64(1), "eu", "2019-06-07 10:09:10", nil, nil},
+		{int64(1), "eu", "2019-07-01 00:00:00", nil, nil},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 2 {
+		t.Fatalf("Expected: delete and index, but: was %+v", reqs)
+	}
+
+	if reqs[0].Action != sink.ActionDelete || reqs[0].Index != "events-2019.06" {
+		t.Errorf("Expected: delete in events-2019.06, but: was %+v", reqs[0])
+	}
+
+	if reqs[1].Action != sink.ActionIndex || reqs[1].Index != "events-2019.07" {
+		t.Errorf("Expected: index in events-2019.07, but: was %+v", reqs[1])
+	}
+
+	reqs, err = r.makeDeleteRequest(rule, [][]interface{}{{int64(1), "eu", "2019-07-01 00:00:00", nil, nil}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 1 || reqs[0].Index != "events-2019.07" {
+		t.Errorf("Expected: delete in events-2019.07, but: was %+v", reqs)
+	}
+}
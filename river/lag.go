@@ -0,0 +1,177 @@
+package river
+
+import (
+	"time"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/fasttrack-solutions/go-mysql/mysql"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+)
+
+// lagInterval is how often the lag served by the metrics is measured.
+const lagInterval = 5 * time.Second
+
+// rowsRequest is the ES requests made for a rows event.
+type rowsRequest struct {
+	reqs []*sink.Event
+
+	// timestamp is when the event was written to the binlog.
+	timestamp uint32
+}
+
+// Lag is the replication delay of the river.
+type Lag struct {
+	// Bytes of binlog between the master position and the saved position.
+	Bytes uint64 `json:"bytes"`
+	// Seconds since the first row event not yet flushed to ES was written to
+	// the binlog, 0 if every read row event has been flushed.
+	Seconds float64 `json:"seconds"`
+}
+
+// binlogFile is a row of SHOW BINARY LOGS.
+type binlogFile struct {
+	Name string
+	Size uint64
+}
+
+// Lag returns the current replication delay.
+func (r *River) Lag() (*Lag, error) {
+	masterPos, err := r.getCanal().GetMasterPos()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	pos := r.master.Position()
+
+	lag := new(Lag)
+
+	if masterPos.Name != pos.Name {
+		files, err := r.binlogFiles()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		lag.Bytes = binlogDiff(masterPos, pos, files)
+	} else {
+		lag.Bytes = binlogDiff(masterPos, pos, nil)
+	}
+
+	lag.Seconds = lagSeconds(time.Now(), r.lastEventTime.Get(), r.flushedEventTime.Get(), r.pendingEventTime.Get())
+
+	return lag, nil
+}
+
+// lagSeconds returns the seconds since the oldest row event not flushed was
+// written, from the binlog timestamps of the last read and flushed row events
+// and of the first one read after the river had flushed everything. Binlog
+// events of the tables without rules do not count, a river reading them is
+// not behind.
+func lagSeconds(now time.Time, read, flushed, pending int64) float64 {
+	if read == flushed {
+		return 0
+	}
+
+	since := flushed
+	if pending > since {
+		since = pending
+	}
+
+	seconds := now.Sub(time.Unix(since, 0)).Seconds()
+	if seconds < 0 {
+		return 0
+	}
+
+	return seconds
+}
+
+// lagLoop measures the lag periodically for the metrics, so scrapes do not
+// query MySQL.
+func (r *River) lagLoop() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(lagInterval)
+	defer ticker.Stop()
+
+	for {
+		lag, err := r.Lag()
+		if err != nil {
+			log.Errorf("get replication lag err %v", err)
+		}
+
+		r.lagLock.Lock()
+		r.lag = lag
+		r.lagLock.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// cachedLag returns the lag last measured by lagLoop, nil if it failed or
+// is not measured yet.
+func (r *River) cachedLag() *Lag {
+	r.lagLock.Lock()
+	defer r.lagLock.Unlock()
+
+	return r.lag
+}
+
+func (r *River) binlogFiles() ([]binlogFile, error) {
+	res, err := r.getCanal().Execute("SHOW BINARY LOGS")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	files := make([]binlogFile, 0, res.Resultset.RowNumber())
+	for i := 0; i < res.Resultset.RowNumber(); i++ {
+		name, _ := res.GetString(i, 0)
+		size, _ := res.GetUint(i, 1)
+
+		files = append(files, binlogFile{Name: name, Size: size})
+	}
+
+	return files, nil
+}
+
+// binlogDiff returns the number of binlog bytes from pos to masterPos. When the
+// positions are in different files, files holds the master binlog files in order
+// with their sizes. 0 is returned if pos is ahead of masterPos.
+func binlogDiff(masterPos, pos mysql.Position, files []binlogFile) uint64 {
+	if masterPos.Compare(pos) <= 0 {
+		return 0
+	}
+
+	if masterPos.Name == pos.Name {
+		return uint64(masterPos.Pos - pos.Pos)
+	}
+
+	// If the file of pos is purged, every file before the master one is counted.
+	counting := true
+	for _, f := range files {
+		if f.Name == pos.Name {
+			counting = false
+			break
+		}
+	}
+
+	var diff uint64
+	for _, f := range files {
+		switch {
+		case f.Name == pos.Name:
+			counting = true
+			if f.Size > uint64(pos.Pos) {
+				diff += f.Size - uint64(pos.Pos)
+			}
+		case f.Name == masterPos.Name:
+			return diff + uint64(masterPos.Pos)
+		case counting:
+			diff += f.Size
+		}
+	}
+
+	return diff + uint64(masterPos.Pos)
+}
@@ -0,0 +1,69 @@
+package river
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fasttrack-solutions/go-mysql/mysql"
+)
+
+func TestBinlogDiff(t *testing.T) {
+	files := []binlogFile{
+		{"mysql-bin.000002", 1000},
+		{"mysql-bin.000003", 2000},
+		{"mysql-bin.000004", 500},
+	}
+
+	tests := []struct {
+		Master mysql.Position
+		Pos    mysql.Position
+		Expect uint64
+	}{
+		// Same file.
+		{mysql.Position{Name: "mysql-bin.000004", Pos: 400}, mysql.Position{Name: "mysql-bin.000004", Pos: 100}, 300},
+		// Caught up.
+		{mysql.Position{Name: "mysql-bin.000004", Pos: 400}, mysql.Position{Name: "mysql-bin.000004", Pos: 400}, 0},
+		// Ahead of the master.
+		{mysql.Position{Name: "mysql-bin.000003", Pos: 400}, mysql.Position{Name: "mysql-bin.000004", Pos: 100}, 0},
+		// Next file.
+		{mysql.Position{Name: "mysql-bin.000004", Pos: 400}, mysql.Position{Name: "mysql-bin.000003", Pos: 1500}, 900},
+		// Several files.
+		{mysql.Position{Name: "mysql-bin.000004", Pos: 400}, mysql.Position{Name: "mysql-bin.000002", Pos: 900}, 2500},
+		// Purged file.
+		{mysql.Position{Name: "mysql-bin.000004", Pos: 400}, mysql.Position{Name: "mysql-bin.000001", Pos: 900}, 3400},
+	}
+
+	for _, test := range tests {
+		if diff := binlogDiff(test.Master, test.Pos, files); diff != test.Expect {
+			t.Errorf("Master: %s, Pos: %s, Expected: is %d, but: was %d", test.Master, test.Pos, test.Expect, diff)
+		}
+	}
+}
+
+func TestLagSeconds(t *testing.T) {
+	now := time.Unix(10000, 0)
+
+	tests := []struct {
+		Read    int64
+		Flushed int64
+		Pending int64
+		Expect  float64
+	}{
+		// Every read row event is flushed, however old.
+		{1000, 1000, 900, 0},
+		// Flushing since the river was caught up.
+		{9990, 1000, 9980, 20},
+		// Flushing behind after the first pending event.
+		{9990, 9950, 9900, 50},
+		// Nothing flushed yet.
+		{9990, 0, 9960, 40},
+		// Clock skew.
+		{10010, 0, 10005, 0},
+	}
+
+	for _, test := range tests {
+		if s := lagSeconds(now, test.Read, test.Flushed, test.Pending); s != test.Expect {
+			t.Errorf("Read: %d, Flushed: %d, Pending: %d, Expected: is %v, but: was %v", test.Read, test.Flushed, test.Pending, test.Expect, s)
+		}
+	}
+}
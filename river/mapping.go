@@ -0,0 +1,329 @@
+package river
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fasttrack-solutions/go-mysql/schema"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+)
+
+// keywordMaxLength is the longest CHAR or VARCHAR mapped as keyword, longer
+// ones are full text.
+const keywordMaxLength = 256
+
+// scaledFloatMaxPrecision is the most digits of a DECIMAL mapped as
+// scaled_float, more are not kept by a double.
+const scaledFloatMaxPrecision = 15
+
+// IndexMapping is a generated index body of the mappings directory.
+type IndexMapping struct {
+	// Name is the index, or the name of the index template.
+	Name string
+	// Template is set for the index template of the rules with an index
+	// template, its body has the index_patterns.
+	Template bool
+	Body     map[string]interface{}
+}
+
+// GenerateMappings generates the index bodies of the rules writing to
+// Elasticsearch from the column types of their tables.
+func GenerateMappings(c *Config) ([]*IndexMapping, error) {
+	cn, err := newCanal(c)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer cn.Close()
+
+	r := &River{c: c}
+	if r.rules, err = buildRules(c, cn); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return r.generateMappings(), nil
+}
+
+// generateMappings returns the index bodies ordered by name. The fields of
+// the rules writing to the same index are merged, the nested rules add their
+// fields to the nested field of the index.
+func (r *River) generateMappings() []*IndexMapping {
+	rules := make([]*Rule, 0, len(r.rules))
+	for _, rule := range r.rules {
+		if len(rule.Sink) == 0 {
+			rules = append(rules, rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].key() < rules[j].key() })
+
+	mappings := make(map[string]*IndexMapping)
+	joins := make(map[string]map[string]*joinRelation)
+	var names []string
+
+	for _, rule := range rules {
+		name, template := rule.Index, rule.index != nil
+		if template {
+			name = templateName(rule.indexPattern())
+		}
+
+		m, ok := mappings[name]
+		if !ok {
+			m = &IndexMapping{Name: name, Template: template, Body: make(map[string]interface{})}
+			if template {
+				m.Body["index_patterns"] = []string{rule.indexPattern()}
+			}
+			m.Body["mappings"] = map[string]interface{}{
+				"_doc": map[string]interface{}{"properties": make(map[string]interface{})},
+			}
+			mappings[name] = m
+			names = append(names, name)
+		}
+
+		props := m.Body["mappings"].(map[string]interface{})["_doc"].(map[string]interface{})["properties"].(map[string]interface{})
+
+		if len(rule.Nested) > 0 {
+			nested, ok := props[rule.Nested].(map[string]interface{})
+			if !ok || nested["type"] != "nested" {
+				nested = map[string]interface{}{"type": "nested", "properties": make(map[string]interface{})}
+				props[rule.Nested] = nested
+			}
+			props = nested["properties"].(map[string]interface{})
+		}
+
+		fields := r.ruleProperties(rule)
+		if len(rule.JoinField) > 0 {
+			if joins[name] == nil {
+				joins[name] = make(map[string]*joinRelation)
+			}
+			j, ok := joins[name][rule.JoinField]
+			if !ok {
+				j = new(joinRelation)
+				joins[name][rule.JoinField] = j
+			}
+			j.add(rule)
+			fields[rule.JoinField] = j.mapping()
+		}
+
+		mergeProperties(rule, props, fields)
+	}
+
+	sort.Strings(names)
+
+	ms := make([]*IndexMapping, 0, len(names))
+	for _, name := range names {
+		ms = append(ms, mappings[name])
+	}
+
+	return ms
+}
+
+// ruleField is the document field of a rule's column.
+type ruleField struct {
+	column  string
+	name    string
+	mapping map[string]interface{}
+}
+
+// ruleFields returns the fields of the rule's documents in column order.
+func (r *River) ruleFields(rule *Rule) []ruleField {
+	var fields []ruleField
+
+	for i := range rule.TableInfo.Columns {
+		col := &rule.TableInfo.Columns[i]
+		// The elements of a nested rule always hold their key.
+		if !rule.CheckFilter(col.Name) && !(len(rule.Nested) > 0 && rule.isKeyColumn(col.Name)) {
+			continue
+		}
+
+		name, mapping := r.fieldMapping(rule, col)
+		fields = append(fields, ruleField{col.Name, name, mapping})
+	}
+
+	return fields
+}
+
+// ruleProperties returns the field mappings of the rule's documents.
+func (r *River) ruleProperties(rule *Rule) map[string]interface{} {
+	props := make(map[string]interface{})
+	for _, f := range r.ruleFields(rule) {
+		props[f.name] = f.mapping
+	}
+
+	return props
+}
+
+// fieldMapping returns the field name and mapping of the column, with the
+// rule's field modifier applied.
+func (r *River) fieldMapping(rule *Rule, col *schema.TableColumn) (string, map[string]interface{}) {
+	v, ok := rule.FieldMapping[col.Name]
+	if !ok {
+		return col.Name, columnMapping(col)
+	}
+
+	_, name, fieldType := r.getFieldParts(col.Name, v)
+	modifier, _ := splitFieldType(fieldType)
+
+	switch modifier {
+	case fieldTypeList, fieldTypeHash, fieldTypeMask, fieldTypeTruncate, fieldTypeString:
+		// ES has no array type, a list is many values of the field.
+		return name, map[string]interface{}{"type": "keyword"}
+	case fieldTypeDate:
+		if col.Type == schema.TYPE_NUMBER {
+			return name, map[string]interface{}{"type": "date"}
+		}
+	case fieldTypeDateMs, fieldTypeDatetime:
+		return name, map[string]interface{}{"type": "date"}
+	case fieldTypeJSON:
+		return name, map[string]interface{}{"type": "object"}
+	case fieldTypeGeoPoint:
+		return name, map[string]interface{}{"type": "geo_point"}
+	case fieldTypeBool:
+		return name, map[string]interface{}{"type": "boolean"}
+	case fieldTypeFloat:
+		return name, map[string]interface{}{"type": "double"}
+	}
+
+	return name, columnMapping(col)
+}
+
+// columnMapping returns the field mapping of the column's values.
+func columnMapping(col *schema.TableColumn) map[string]interface{} {
+	rawType := strings.ToLower(col.RawType)
+
+	switch col.Type {
+	case schema.TYPE_NUMBER:
+		switch {
+		case strings.HasPrefix(rawType, "tinyint"), strings.HasPrefix(rawType, "year"):
+			return map[string]interface{}{"type": "short"}
+		case strings.HasPrefix(rawType, "smallint"), strings.HasPrefix(rawType, "mediumint"):
+			return map[string]interface{}{"type": "integer"}
+		case strings.HasPrefix(rawType, "int") && !col.IsUnsigned:
+			return map[string]interface{}{"type": "integer"}
+		}
+		return map[string]interface{}{"type": "long"}
+	case schema.TYPE_FLOAT:
+		if strings.HasPrefix(rawType, "float") {
+			return map[string]interface{}{"type": "float"}
+		}
+		return map[string]interface{}{"type": "double"}
+	case schema.TYPE_DECIMAL:
+		precision, scale := typeLength(rawType)
+		if precision > scaledFloatMaxPrecision {
+			return map[string]interface{}{"type": "double"}
+		}
+		return map[string]interface{}{"type": "scaled_float", "scaling_factor": math.Pow10(scale)}
+	case schema.TYPE_STRING:
+		if n, _ := typeLength(rawType); n > 0 && n <= keywordMaxLength && strings.Contains(rawType, "char") {
+			return map[string]interface{}{"type": "keyword"}
+		}
+		if strings.Contains(rawType, "binary") {
+			return map[string]interface{}{"type": "keyword"}
+		}
+		return map[string]interface{}{"type": "text"}
+	case schema.TYPE_DATETIME, schema.TYPE_TIMESTAMP, schema.TYPE_DATE:
+		return map[string]interface{}{"type": "date"}
+	case schema.TYPE_BIT:
+		return map[string]interface{}{"type": "long"}
+	case schema.TYPE_JSON:
+		return map[string]interface{}{"type": "object"}
+	}
+
+	// ENUM, SET and TIME values are strings.
+	return map[string]interface{}{"type": "keyword"}
+}
+
+// typeLength returns the length and decimals of the column type, e.g. 10 and
+// 2 of "decimal(10,2)", 0 if they are not set.
+func typeLength(rawType string) (int, int) {
+	start := strings.IndexByte(rawType, '(')
+	end := strings.IndexByte(rawType, ')')
+	if start < 0 || end < start {
+		return 0, 0
+	}
+
+	parts := strings.SplitN(rawType[start+1:end], ",", 2)
+	n, _ := strconv.Atoi(strings.TrimSpace(parts[0]))
+	d := 0
+	if len(parts) == 2 {
+		d, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
+	}
+
+	return n, d
+}
+
+// isKeyColumn checks whether the column is in the rule's ID, or in the
+// primary key if it has none.
+func (r *Rule) isKeyColumn(column string) bool {
+	if r.ID != nil {
+		for _, c := range r.ID {
+			if c == column {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, i := range r.TableInfo.PKColumns {
+		if r.TableInfo.Columns[i].Name == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergeProperties adds the fields to the properties, a field already mapped
+// to another type by another rule is kept.
+func mergeProperties(rule *Rule, props map[string]interface{}, fields map[string]interface{}) {
+	for k, v := range fields {
+		old, ok := props[k].(map[string]interface{})
+		if ok && old["type"] != v.(map[string]interface{})["type"] {
+			log.Warnf("field %s of rule %s is %v, but already %v", k, rule.key(), v.(map[string]interface{})["type"], old["type"])
+			continue
+		}
+		props[k] = v
+	}
+}
+
+// joinRelation holds the join names of the parent and child rules of a join field.
+type joinRelation struct {
+	parents  []string
+	children []string
+}
+
+func (j *joinRelation) add(rule *Rule) {
+	if len(rule.Parent) > 0 {
+		j.children = appendUnique(j.children, rule.JoinName)
+	} else {
+		j.parents = appendUnique(j.parents, rule.JoinName)
+	}
+}
+
+// mapping returns the join field mapping, the children are only related to
+// the parent if there is one.
+func (j *joinRelation) mapping() map[string]interface{} {
+	relations := make(map[string]interface{})
+	if len(j.parents) == 1 && len(j.children) > 0 {
+		relations[j.parents[0]] = j.children
+	}
+
+	return map[string]interface{}{"type": "join", "relations": relations}
+}
+
+func appendUnique(s []string, v string) []string {
+	for _, e := range s {
+		if e == v {
+			return s
+		}
+	}
+
+	return append(s, v)
+}
+
+// templateName returns the name of the index template of the index pattern,
+// e.g. events of events-*.
+func templateName(pattern string) string {
+	return strings.Trim(strings.Replace(pattern, "*", "", -1), "-_.")
+}
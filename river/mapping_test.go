@@ -0,0 +1,162 @@
+package river
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/fasttrack-solutions/go-mysql/schema"
+)
+
+func newMappingRule(table string, index string) *Rule {
+	rule := newDefaultRule("test", table)
+	rule.Index = index
+	rule.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   table,
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER, RawType: "bigint(20) unsigned", IsUnsigned: true},
+			{Name: "title", Type: schema.TYPE_STRING, RawType: "varchar(64)"},
+			{Name: "body", Type: schema.TYPE_STRING, RawType: "text"},
+			{Name: "price", Type: schema.TYPE_DECIMAL, RawType: "decimal(10,2)"},
+			{Name: "created_at", Type: schema.TYPE_DATETIME, RawType: "datetime"},
+			{Name: "ts", Type: schema.TYPE_NUMBER, RawType: "int(11)"},
+			{Name: "tags", Type: schema.TYPE_STRING, RawType: "varchar(255)"},
+			{Name: "attrs", Type: schema.TYPE_JSON, RawType: "json"},
+			{Name: "status", Type: schema.TYPE_ENUM, RawType: "enum('a','b')"},
+			{Name: "secret", Type: schema.TYPE_STRING, RawType: "varchar(512)"},
+		},
+		PKColumns: []int{0},
+	}
+
+	return rule
+}
+
+func mappingJSON(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestColumnMapping(t *testing.T) {
+	tests := []struct {
+		Col     schema.TableColumn
+		Mapping string
+	}{
+		{schema.TableColumn{Type: schema.TYPE_NUMBER, RawType: "tinyint(1)"}, `{"type":"short"}`},
+		{schema.TableColumn{Type: schema.TYPE_NUMBER, RawType: "mediumint(9)"}, `{"type":"integer"}`},
+		{schema.TableColumn{Type: schema.TYPE_NUMBER, RawType: "int(11)"}, `{"type":"integer"}`},
+		{schema.TableColumn{Type: schema.TYPE_NUMBER, RawType: "int(10) unsigned", IsUnsigned: true}, `{"type":"long"}`},
+		{schema.TableColumn{Type: schema.TYPE_NUMBER, RawType: "bigint(20)"}, `{"type":"long"}`},
+		{schema.TableColumn{Type: schema.TYPE_FLOAT, RawType: "float"}, `{"type":"float"}`},
+		{schema.TableColumn{Type: schema.TYPE_FLOAT, RawType: "double"}, `{"type":"double"}`},
+		{schema.TableColumn{Type: schema.TYPE_DECIMAL, RawType: "decimal(10,2)"}, `{"scaling_factor":100,"type":"scaled_float"}`},
+		{schema.TableColumn{Type: schema.TYPE_DECIMAL, RawType: "decimal(30,10)"}, `{"type":"double"}`},
+		{schema.TableColumn{Type: schema.TYPE_STRING, RawType: "char(2)"}, `{"type":"keyword"}`},
+		{schema.TableColumn{Type: schema.TYPE_STRING, RawType: "varchar(256)"}, `{"type":"keyword"}`},
+		{schema.TableColumn{Type: schema.TYPE_STRING, RawType: "varchar(1024)"}, `{"type":"text"}`},
+		{schema.TableColumn{Type: schema.TYPE_STRING, RawType: "longtext"}, `{"type":"text"}`},
+		{schema.TableColumn{Type: schema.TYPE_STRING, RawType: "varbinary(16)"}, `{"type":"keyword"}`},
+		{schema.TableColumn{Type: schema.TYPE_ENUM, RawType: "enum('a')"}, `{"type":"keyword"}`},
+		{schema.TableColumn{Type: schema.TYPE_SET, RawType: "set('a')"}, `{"type":"keyword"}`},
+		{schema.TableColumn{Type: schema.TYPE_TIMESTAMP, RawType: "timestamp"}, `{"type":"date"}`},
+		{schema.TableColumn{Type: schema.TYPE_DATE, RawType: "date"}, `{"type":"date"}`},
+		{schema.TableColumn{Type: schema.TYPE_TIME, RawType: "time"}, `{"type":"keyword"}`},
+		{schema.TableColumn{Type: schema.TYPE_BIT, RawType: "bit(8)"}, `{"type":"long"}`},
+		{schema.TableColumn{Type: schema.TYPE_JSON, RawType: "json"}, `{"type":"object"}`},
+	}
+
+	for _, test := range tests {
+		if m := mappingJSON(t, columnMapping(&test.Col)); m != test.Mapping {
+			t.Errorf("Type: %s, Expected: is %s, but: was %s", test.Col.RawType, test.Mapping, m)
+		}
+	}
+}
+
+func TestGenerateMappings(t *testing.T) {
+	posts := newMappingRule("posts", "posts")
+	posts.FieldMapping = map[string]string{
+		"title":  "name",
+		"price":  ",string",
+		"ts":     "updated_at,date",
+		"tags":   ",list",
+		"secret": ",hash",
+	}
+	posts.Exclude = []string{"body"}
+	posts.JoinField = "relation"
+	posts.JoinName = "post"
+
+	comments := newMappingRule("comments", "posts")
+	comments.Filter = []string{"body", "created_at"}
+	comments.Parent = "id"
+	comments.JoinField = "relation"
+	comments.JoinName = "comment"
+
+	likes := newMappingRule("likes", "posts")
+	likes.Filter = []string{"created_at"}
+	likes.Nested = "likes"
+	likes.NestedParent = "ts"
+
+	events := newMappingRule("events", "events-{created_at:2006.01}")
+	events.Filter = []string{"id", "attrs", "status"}
+
+	kafka := newMappingRule("orders", "orders")
+	kafka.Sink = "kafka"
+
+	r := &River{c: new(Config), rules: make(map[string]*Rule)}
+	for _, rule := range []*Rule{posts, comments, likes, events, kafka} {
+		if err := rule.prepare(); err != nil {
+			t.Fatal(err)
+		}
+		r.rules[rule.key()] = rule
+	}
+
+	ms := r.generateMappings()
+	if len(ms) != 2 {
+		t.Fatalf("Expected: events and posts, but: was %s", mappingJSON(t, ms))
+	}
+
+	expected := []struct {
+		Name     string
+		Template bool
+		Body     string
+	}{
+		{"events", true, `{"index_patterns":["events-*"],"mappings":{"_doc":{"properties":{` +
+			`"attrs":{"type":"object"},"id":{"type":"long"},"status":{"type":"keyword"}}}}}`},
+		{"posts", false, `{"mappings":{"_doc":{"properties":{` +
+			`"attrs":{"type":"object"},` +
+			`"body":{"type":"text"},` +
+			`"created_at":{"type":"date"},` +
+			`"id":{"type":"long"},` +
+			`"likes":{"properties":{"created_at":{"type":"date"},"id":{"type":"long"}},"type":"nested"},` +
+			`"name":{"type":"keyword"},` +
+			`"price":{"type":"keyword"},` +
+			`"relation":{"relations":{"post":["comment"]},"type":"join"},` +
+			`"secret":{"type":"keyword"},` +
+			`"status":{"type":"keyword"},` +
+			`"tags":{"type":"keyword"},` +
+			`"updated_at":{"type":"date"}}}}}`},
+	}
+
+	for i, e := range expected {
+		m := ms[i]
+		if m.Name != e.Name || m.Template != e.Template {
+			t.Errorf("Expected: %s template %v, but: was %s template %v", e.Name, e.Template, m.Name, m.Template)
+		}
+
+		var want map[string]interface{}
+		if err := json.Unmarshal([]byte(e.Body), &want); err != nil {
+			t.Fatal(err)
+		}
+
+		var have map[string]interface{}
+		json.Unmarshal([]byte(mappingJSON(t, m.Body)), &have)
+
+		if !reflect.DeepEqual(want, have) {
+			t.Errorf("Index: %s, Expected: is %s, but: was %s", e.Name, e.Body, mappingJSON(t, m.Body))
+		}
+	}
+}
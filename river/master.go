@@ -26,11 +26,13 @@ const (
 	redisMIHashKey    string = "go-mysql-elasticsearch-master-info"
 	redisBinNameField string = "bin_name"
 	redisBinPosField  string = "bin_pos"
+	redisGTIDSetField string = "gtid_set"
 )
 
 type masterInfoData struct {
-	Name string `toml:"bin_name"`
-	Pos  uint32 `toml:"bin_pos"`
+	Name    string `toml:"bin_name"`
+	Pos     uint32 `toml:"bin_pos"`
+	GTIDSet string `toml:"gtid_set"`
 }
 
 type masterInfo struct {
@@ -131,6 +133,9 @@ func (m *masterInfo) load() error {
 
 		m.Data.Pos = uint32(posInt)
 
+		// GTID set is optional, master info saved by older versions doesn't have it.
+		m.Data.GTIDSet = data[redisGTIDSetField]
+
 	case fsStorageMore:
 		f, err := os.Open(m.filePath)
 		if err != nil && !os.IsNotExist(errors.Cause(err)) {
@@ -148,8 +153,8 @@ func (m *masterInfo) load() error {
 	return nil
 }
 
-func (m *masterInfo) Save(pos mysql.Position) error {
-	log.Infof("save position %s", pos)
+func (m *masterInfo) Save(pos mysql.Position, gset mysql.GTIDSet) error {
+	log.Infof("save position %s, GTID set %v", pos, gset)
 
 	m.Lock()
 	defer m.Unlock()
@@ -157,6 +162,10 @@ func (m *masterInfo) Save(pos mysql.Position) error {
 	m.Data.Name = pos.Name
 	m.Data.Pos = pos.Pos
 
+	if gset != nil {
+		m.Data.GTIDSet = gset.String()
+	}
+
 	n := time.Now()
 	if n.Sub(m.lastSaveTime) < time.Second {
 		return nil
@@ -169,6 +178,7 @@ func (m *masterInfo) Save(pos mysql.Position) error {
 		m.redisClient.HMSet(key, map[string]interface{}{
 			redisBinNameField: m.Data.Name,
 			redisBinPosField:  m.Data.Pos,
+			redisGTIDSetField: m.Data.GTIDSet,
 		})
 
 	case fsStorageMore:
@@ -202,8 +212,21 @@ func (m *masterInfo) Position() mysql.Position {
 	}
 }
 
+// GTIDSet returns the saved GTID set, nil if no GTID set has been saved yet.
+func (m *masterInfo) GTIDSet(flavor string) (mysql.GTIDSet, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if len(m.Data.GTIDSet) == 0 {
+		return nil, nil
+	}
+
+	gset, err := mysql.ParseGTIDSet(flavor, m.Data.GTIDSet)
+	return gset, errors.Trace(err)
+}
+
 func (m *masterInfo) Close() error {
 	pos := m.Position()
 
-	return m.Save(pos)
+	return m.Save(pos, nil)
 }
@@ -0,0 +1,126 @@
+package river
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "river"
+
+var (
+	rowsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rows_total",
+		Help:      "Number of synced rows by rule and action.",
+	}, []string{"rule", "action"})
+
+	bulkSizeHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "bulk_size",
+		Help:      "Number of items in ES bulk requests.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	bulkDurationHistogram = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "bulk_duration_seconds",
+		Help:      "Latency of ES bulk requests.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+
+	bulkRetriesCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "bulk_retries_total",
+		Help:      "Number of retried ES bulk requests.",
+	})
+
+	bulkItemErrorsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "bulk_item_errors_total",
+		Help:      "Number of failed ES bulk items by status code.",
+	}, []string{"status"})
+
+	versionConflictsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "version_conflicts_total",
+		Help:      "Number of ES bulk items skipped as a newer version of the document exists.",
+	})
+
+	deadLettersCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dead_letters_total",
+		Help:      "Number of bulk items stored as dead letters.",
+	})
+
+	backfillRowsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "backfill_rows_total",
+		Help:      "Number of rows read by backfills by rule.",
+	}, []string{"rule"})
+
+	checkDocsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "check_inconsistent_docs",
+		Help:      "Number of inconsistent ES documents found by the last check by rule and kind.",
+	}, []string{"rule", "kind"})
+
+	checkRepairedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "check_repaired_docs_total",
+		Help:      "Number of ES documents repaired by checks by rule.",
+	}, []string{"rule"})
+)
+
+func init() {
+	prometheus.MustRegister(rowsCounter)
+	prometheus.MustRegister(bulkSizeHistogram)
+	prometheus.MustRegister(bulkDurationHistogram)
+	prometheus.MustRegister(bulkRetriesCounter)
+	prometheus.MustRegister(bulkItemErrorsCounter)
+	prometheus.MustRegister(versionConflictsCounter)
+	prometheus.MustRegister(deadLettersCounter)
+	prometheus.MustRegister(backfillRowsCounter)
+	prometheus.MustRegister(checkDocsGauge)
+	prometheus.MustRegister(checkRepairedCounter)
+}
+
+// collector exposes the state of a running river, it is evaluated on every scrape.
+type collector struct {
+	r *River
+
+	syncQueueLength *prometheus.Desc
+	lagBytes        *prometheus.Desc
+	lagSeconds      *prometheus.Desc
+}
+
+func newCollector(r *River) *collector {
+	return &collector{
+		r: r,
+		syncQueueLength: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "sync_queue_length"),
+			"Number of pending items in the sync queue.", nil, nil),
+		lagBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "binlog_lag_bytes"),
+			"Bytes of binlog between the master and the saved position.", nil, nil),
+		lagSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "binlog_lag_seconds"),
+			"Seconds since the first row event not yet flushed to ES was written to the binlog, 0 when every read row event is flushed.", nil, nil),
+	}
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.syncQueueLength
+	ch <- c.lagBytes
+	ch <- c.lagSeconds
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.syncQueueLength, prometheus.GaugeValue, float64(len(c.r.syncCh)))
+
+	lag := c.r.cachedLag()
+	if lag == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.lagBytes, prometheus.GaugeValue, float64(lag.Bytes))
+	ch <- prometheus.MustNewConstMetric(c.lagSeconds, prometheus.GaugeValue, lag.Seconds)
+}
@@ -0,0 +1,238 @@
+package river
+
+import (
+	"sort"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/fasttrack-solutions/go-mysql/canal"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+)
+
+// nestedScript replaces the element with the key in the array field of the
+// document, the element is only removed if params.doc is null. Values are
+// compared as strings as ES may parse a number to another type than the param.
+const nestedScript = `def key = params.key;
+if (ctx._source[params.field] == null) { ctx._source[params.field] = new ArrayList(); }
+ctx._source[params.field].removeIf(item -> {
+  for (e in key.entrySet()) {
+    if (String.valueOf(item[e.getKey()]) != String.valueOf(e.getValue())) { return false; }
+  }
+  return true;
+});
+if (params.doc != null) { ctx._source[params.field].add(params.doc); }`
+
+// keepNestedScript replaces the document by params.doc, but for the nested
+// fields of params.fields.
+const keepNestedScript = `def kept = new HashMap();
+for (f in params.fields) {
+  if (ctx._source.containsKey(f)) { kept[f] = ctx._source[f]; }
+}
+ctx._source.clear();
+ctx._source.putAll(params.doc);
+ctx._source.putAll(kept);`
+
+// linkNestedRules sets the nested fields of the rules indexing the documents
+// the nested rules update. A rule with a pipeline or external version can only
+// index whole documents, and would drop the nested fields.
+func linkNestedRules(rules map[string]*Rule) error {
+	for _, rule := range rules {
+		rule.nestedFields = nil
+	}
+
+	for _, nested := range rules {
+		if len(nested.Nested) == 0 {
+			continue
+		}
+
+		for _, rule := range rules {
+			if len(rule.Nested) > 0 || len(rule.Sink) > 0 || rule.index != nil || rule.Index != nested.Index {
+				continue
+			}
+
+			if len(rule.Pipeline) > 0 || rule.ExternalVersion {
+				return errors.Errorf("rule %s with a pipeline or external_version would drop the nested field %s of rule %s",
+					rule.key(), nested.Nested, nested.key())
+			}
+
+			rule.nestedFields = appendUnique(rule.nestedFields, nested.Nested)
+			sort.Strings(rule.nestedFields)
+		}
+	}
+
+	return nil
+}
+
+// sortNestedLast sorts the rules by key, the nested rules after the rules
+// indexing the documents they update.
+func sortNestedLast(rules []*Rule) {
+	sort.Slice(rules, func(i, j int) bool {
+		if a, b := len(rules[i].Nested) > 0, len(rules[j].Nested) > 0; a != b {
+			return b
+		}
+		return rules[i].key() < rules[j].key()
+	})
+}
+
+// keepNested turns the index request of a document with nested fields into
+// an update replacing the document but its nested fields, the document is
+// indexed if it is missing.
+func keepNested(req *sink.Event, rule *Rule) {
+	if len(rule.nestedFields) == 0 || req.Action != sink.ActionIndex {
+		return
+	}
+
+	req.Action = sink.ActionUpdate
+	req.Script = &sink.Script{
+		Source: keepNestedScript,
+		Params: map[string]interface{}{"fields": rule.nestedFields, "doc": req.Data},
+	}
+	req.Upsert = req.Data
+	req.Data = nil
+}
+
+// makeNestedRequest makes the scripted updates of the parent documents for
+// the rows of a nested rule.
+func (r *River) makeNestedRequest(rule *Rule, action string, rows [][]interface{}) ([]*sink.Event, error) {
+	step := 1
+	if action == canal.UpdateAction {
+		if len(rows)%2 != 0 {
+			return nil, errors.Errorf("invalid update rows event, must have 2x rows, but %d", len(rows))
+		}
+		step = 2
+	}
+
+	reqs := make([]*sink.Event, 0, len(rows))
+	add := func(req *sink.Event, err error) error {
+		if req != nil {
+			reqs = append(reqs, req)
+		}
+		return errors.Trace(err)
+	}
+
+	for i := 0; i < len(rows); i += step {
+		var err error
+		switch action {
+		case canal.InsertAction:
+			err = add(r.makeNestedEvent(rule, rows[i], rows[i], true))
+			r.st.InsertNum.Add(1)
+		case canal.DeleteAction:
+			err = add(r.makeNestedEvent(rule, rows[i], rows[i], false))
+			r.st.DeleteNum.Add(1)
+		case canal.UpdateAction:
+			before, after := rows[i], rows[i+1]
+			if nestedParentID(rule, before) != nestedParentID(rule, after) {
+				// The row moved to another document.
+				if err = add(r.makeNestedEvent(rule, before, before, false)); err == nil {
+					err = add(r.makeNestedEvent(rule, after, after, true))
+				}
+			} else {
+				err = add(r.makeNestedEvent(rule, before, after, true))
+			}
+			r.st.UpdateNum.Add(1)
+		default:
+			err = errors.Errorf("invalid rows action %s", action)
+		}
+
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		rowsCounter.WithLabelValues(rule.key(), action).Inc()
+	}
+
+	return reqs, nil
+}
+
+// makeNestedEvent makes the update of the row's parent document removing the
+// element with the key of the row before, and adding the row if keep is set.
+// nil is returned for a row without a parent.
+func (r *River) makeNestedEvent(rule *Rule, before, values []interface{}, keep bool) (*sink.Event, error) {
+	parentID := nestedParentID(rule, values)
+	if len(parentID) == 0 {
+		log.Warnf("skip row of nested rule %s without %s", rule.key(), rule.NestedParent)
+		return nil, nil
+	}
+
+	key, err := r.nestedKey(rule, before)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// A soft deleted or filtered out row is removed.
+	keep = keep && rule.indexed(values)
+
+	params := map[string]interface{}{
+		"field": rule.Nested,
+		"key":   key,
+		"doc":   nil,
+	}
+
+	if keep {
+		elem := new(sink.Event)
+		r.makeInsertReqData(elem, rule, values)
+
+		// The element must hold its key to be found again.
+		newKey, err := r.nestedKey(rule, values)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for k, v := range newKey {
+			elem.Data[k] = v
+		}
+		params["doc"] = elem.Data
+	}
+
+	req := newEvent(rule, parentID, values)
+	req.Action = sink.ActionUpdate
+	req.Script = &sink.Script{Source: nestedScript, Params: params}
+
+	return req, nil
+}
+
+// nestedKey returns the fields identifying the row's element, the ID columns
+// of the rule or the primary key.
+func (r *River) nestedKey(rule *Rule, values []interface{}) (map[string]interface{}, error) {
+	columns := rule.ID
+	if columns == nil {
+		for _, i := range rule.TableInfo.PKColumns {
+			columns = append(columns, rule.TableInfo.Columns[i].Name)
+		}
+	}
+
+	key := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		i := rule.TableInfo.FindColumn(column)
+		if i < 0 {
+			return nil, errors.Errorf("table %s.%s has no column %s", rule.Schema, rule.Table, column)
+		}
+
+		if values[i] == nil {
+			return nil, errors.Errorf("the id column %s of nested rule %s is nil", column, rule.key())
+		}
+
+		c := &rule.TableInfo.Columns[i]
+		name, value := c.Name, makeReqColumnData(c, values[i])
+		for k, v := range rule.FieldMapping {
+			mysql, elastic, fieldType := r.getFieldParts(k, v)
+			if mysql == c.Name {
+				name, value = elastic, r.getFieldValue(c, fieldType, values[i])
+			}
+		}
+
+		key[name] = value
+	}
+
+	return key, nil
+}
+
+// nestedParentID returns the ID of the document the row belongs to, empty if
+// the parent column is NULL.
+func nestedParentID(rule *Rule, values []interface{}) string {
+	v, err := rule.TableInfo.GetColumnValue(rule.NestedParent, values)
+	if err != nil {
+		return ""
+	}
+
+	return columnString(v)
+}
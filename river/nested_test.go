@@ -0,0 +1,139 @@
+package river
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/fasttrack-solutions/go-mysql/canal"
+	"github.com/fasttrack-solutions/go-mysql/schema"
+)
+
+func TestMakeNestedRequest(t *testing.T) {
+	r := &River{st: &stat{}}
+
+	rule := newDefaultRule("test", "user_tags")
+	rule.Index = "users"
+	rule.Nested = "tags"
+	rule.NestedParent = "user_id"
+	rule.Filter = []string{"tag"}
+	rule.FieldMapping = map[string]string{"id": "tag_id"}
+	rule.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   "user_tags",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "user_id", Type: schema.TYPE_NUMBER},
+			{Name: "tag", Type: schema.TYPE_STRING},
+		},
+		PKColumns: []int{0},
+	}
+
+	reqs, err := r.makeNestedRequest(rule, canal.InsertAction, [][]interface{}{
+		{int64(1), int64(10), "a"},
+		{int64(2), nil, "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 1 {
+		t.Fatalf("Expected: the row without a parent is skipped, but: was %+v", reqs)
+	}
+
+	req := reqs[0]
+	if req.Action != sink.ActionUpdate || req.Index != "users" || req.ID != "10" || req.Script == nil {
+		t.Fatalf("Expected: scripted update of users 10, but: was %+v", req)
+	}
+
+	expect := map[string]interface{}{
+		"field": "tags",
+		"key":   map[string]interface{}{"tag_id": int64(1)},
+		"doc":   map[string]interface{}{"tag_id": int64(1), "tag": "a"},
+	}
+	if !reflect.DeepEqual(req.Script.Params, expect) {
+		t.Errorf("Expected: is %v, but: was %v", expect, req.Script.Params)
+	}
+
+	reqs, err = r.makeNestedRequest(rule, canal.UpdateAction, [][]interface{}{
+		{int64(1), int64(10), "a"},
+		{int64(1), int64(11), "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 2 || reqs[0].ID != "10" || reqs[1].ID != "11" {
+		t.Fatalf("Expected: updates of 10 and 11, but: was %+v", reqs)
+	}
+
+	if reqs[0].Script.Params["doc"] != nil || reqs[1].Script.Params["doc"] == nil {
+		t.Errorf("Expected: element moved from 10 to 11, but: was %v and %v", reqs[0].Script.Params, reqs[1].Script.Params)
+	}
+
+	reqs, err = r.makeNestedRequest(rule, canal.DeleteAction, [][]interface{}{
+		{int64(1), int64(11), "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 1 || reqs[0].ID != "11" || reqs[0].Script.Params["doc"] != nil {
+		t.Errorf("Expected: element removed from 11, but: was %+v", reqs)
+	}
+}
+
+func TestLinkNestedRules(t *testing.T) {
+	r := &River{st: &stat{}}
+
+	users := newDefaultRule("test", "users")
+	users.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   "users",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "name", Type: schema.TYPE_STRING},
+		},
+		PKColumns: []int{0},
+	}
+
+	tags := newDefaultRule("test", "user_tags")
+	tags.Index = "users"
+	tags.Nested = "tags"
+	tags.NestedParent = "user_id"
+
+	rules := map[string]*Rule{users.key(): users, tags.key(): tags}
+	if err := linkNestedRules(rules); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(users.nestedFields, []string{"tags"}) || tags.nestedFields != nil {
+		t.Fatalf("Expected: users keeps tags, but: was %v and %v", users.nestedFields, tags.nestedFields)
+	}
+
+	sorted := []*Rule{tags, users}
+	sortNestedLast(sorted)
+	if sorted[0] != users {
+		t.Errorf("Expected: users before user_tags, but: was %s", sorted[0].key())
+	}
+
+	reqs, err := r.makeInsertRequest(users, [][]interface{}{{int64(1), "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := reqs[0]
+	doc := map[string]interface{}{"id": int64(1), "name": "a"}
+	if req.Action != sink.ActionUpdate || req.Script == nil || req.Data != nil || !reflect.DeepEqual(req.Upsert, doc) {
+		t.Fatalf("Expected: scripted upsert of users 1, but: was %+v", req)
+	}
+
+	if !reflect.DeepEqual(req.Script.Params["fields"], []string{"tags"}) || !reflect.DeepEqual(req.Script.Params["doc"], doc) {
+		t.Errorf("Expected: doc replaced but for tags, but: was %v", req.Script.Params)
+	}
+
+	users.Pipeline = "enrich"
+	if err := linkNestedRules(rules); err == nil {
+		t.Errorf("Expected: error for a pipeline dropping tags, but: was nil")
+	}
+}
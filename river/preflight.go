@@ -0,0 +1,220 @@
+package river
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+)
+
+// The policies of the mapping check run before syncing.
+const (
+	mappingCheckOff  = "off"
+	mappingCheckWarn = "warn"
+	mappingCheckFail = "fail"
+)
+
+// mappingTypes are the ES field types accepting the values of the generated
+// field types. Numbers are taken as epoch_millis by a date field, strings are
+// only accepted by a date or ip field in its format, so they are not.
+var mappingTypes = map[string][]string{
+	"number":    {"byte", "short", "integer", "long", "unsigned_long", "float", "half_float", "double", "scaled_float", "keyword", "text", "date"},
+	"keyword":   {"keyword", "text", "wildcard", "constant_keyword", "match_only_text", "search_as_you_type", "completion"},
+	"date":      {"date", "date_nanos", "keyword", "text"},
+	"boolean":   {"boolean", "keyword"},
+	"object":    {"object", "nested", "flattened"},
+	"geo_point": {"geo_point", "geo_shape"},
+	"nested":    {"nested"},
+	"join":      {"join"},
+}
+
+// checkMappingPolicy checks the policy of the mapping check.
+func checkMappingPolicy(policy string) error {
+	switch policy {
+	case "", mappingCheckOff, mappingCheckWarn, mappingCheckFail:
+		return nil
+	}
+
+	return errors.Errorf("invalid mapping check %q, must be off, warn or fail", policy)
+}
+
+// checkMappings compares the rules with the mappings of the existing indices
+// they write to. The issues are logged, and fail the check with the fail
+// policy.
+func (r *River) checkMappings(rules map[string]*Rule) error {
+	policy := r.c.MappingCheck
+	if len(policy) == 0 || policy == mappingCheckOff {
+		return nil
+	}
+
+	keys := make([]string, 0, len(rules))
+	for key := range rules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var issues []string
+	indices := make(map[string]map[string]interface{})
+
+	for _, key := range keys {
+		rule := rules[key]
+		if len(rule.Sink) > 0 {
+			continue
+		}
+
+		issues = append(issues, rule.missingFieldColumns()...)
+
+		pattern := rule.indexPattern()
+		mappings, ok := indices[pattern]
+		if !ok {
+			var err error
+			if mappings, err = r.indexMappings(pattern); err != nil {
+				if policy == mappingCheckFail {
+					return errors.Annotatef(err, "get mapping of %s", pattern)
+				}
+				log.Warnf("skip mapping check of %s, get mapping err %v", pattern, err)
+			}
+			indices[pattern] = mappings
+		}
+
+		names := make([]string, 0, len(mappings))
+		for name := range mappings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			mapping, _ := mappings[name].(map[string]interface{})
+			issues = append(issues, r.checkRuleMapping(rule, name, mapping)...)
+		}
+	}
+
+	for _, issue := range issues {
+		log.Warnf("mapping check: %s", issue)
+	}
+
+	if len(issues) > 0 && policy == mappingCheckFail {
+		return errors.Errorf("%d rule mapping issues: %s", len(issues), strings.Join(issues, "; "))
+	}
+
+	return nil
+}
+
+// indexMappings returns the mappings of the indices matching the index
+// pattern by index, nil if the index does not exist yet.
+func (r *River) indexMappings(pattern string) (map[string]interface{}, error) {
+	if !strings.Contains(pattern, "*") {
+		exists, err := r.es.Exists(pattern)
+		if err != nil || !exists {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	mappings, err := r.es.GetIndexMappings(pattern)
+	return mappings, errors.Trace(err)
+}
+
+// missingFieldColumns returns the issues of the field mappings of columns
+// the table does not have.
+func (r *Rule) missingFieldColumns() []string {
+	var issues []string
+	for column := range r.FieldMapping {
+		if r.TableInfo.FindColumn(column) < 0 {
+			issues = append(issues, fmt.Sprintf("field of rule %s maps column %s which does not exist", r.key(), column))
+		}
+	}
+	sort.Strings(issues)
+
+	return issues
+}
+
+// checkRuleMapping returns the issues of the rule's documents written to the
+// index with the mapping: fields of a type the index does not accept, and
+// fields missing in a strict mapping.
+func (r *River) checkRuleMapping(rule *Rule, index string, mapping map[string]interface{}) []string {
+	props, _ := mapping["properties"].(map[string]interface{})
+	dynamic := mapping["dynamic"]
+
+	var issues []string
+	check := func(name string, column string, want string) {
+		what := fmt.Sprintf("field %s of rule %s", name, rule.key())
+		if len(column) > 0 && column != name {
+			what += fmt.Sprintf(" (column %s)", column)
+		}
+
+		field, ok := props[name].(map[string]interface{})
+		if !ok {
+			if isStrict(dynamic) {
+				issues = append(issues, fmt.Sprintf("%s is not in the strict mapping of index %s", what, index))
+			}
+			return
+		}
+
+		if have := mappingFieldType(field); !acceptsType(have, want) {
+			issues = append(issues, fmt.Sprintf("%s is %s in index %s, but the rule writes %s", what, have, index, want))
+		}
+	}
+
+	if len(rule.Nested) > 0 {
+		check(rule.Nested, "", "nested")
+
+		field, _ := props[rule.Nested].(map[string]interface{})
+		if field == nil || mappingFieldType(field) != "nested" {
+			return issues
+		}
+
+		props, _ = field["properties"].(map[string]interface{})
+		if d, ok := field["dynamic"]; ok {
+			dynamic = d
+		}
+	}
+
+	for _, f := range r.ruleFields(rule) {
+		// A field without a type, e.g. an object of a mappings file, is not
+		// checked.
+		if want, ok := f.mapping["type"].(string); ok {
+			check(f.name, f.column, want)
+		}
+	}
+
+	if len(rule.JoinField) > 0 {
+		check(rule.JoinField, "", "join")
+	}
+
+	return issues
+}
+
+// mappingFieldType returns the type of the field mapping, object if it has none.
+func mappingFieldType(m map[string]interface{}) string {
+	if t, ok := m["type"].(string); ok {
+		return t
+	}
+
+	return "object"
+}
+
+// acceptsType checks whether a field of the ES type accepts the values of
+// the generated field type.
+func acceptsType(have string, want string) bool {
+	switch want {
+	case "short", "integer", "long", "float", "double", "scaled_float":
+		want = "number"
+	case "text":
+		want = "keyword"
+	}
+
+	for _, t := range mappingTypes[want] {
+		if t == have {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isStrict checks whether unknown fields are rejected by the mapping.
+func isStrict(dynamic interface{}) bool {
+	return fmt.Sprintf("%v", dynamic) == "strict"
+}
@@ -0,0 +1,84 @@
+package river
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCheckRuleMapping(t *testing.T) {
+	posts := newMappingRule("posts", "posts")
+	posts.Filter = []string{"id", "title", "price", "ts", "created_at"}
+	posts.FieldMapping = map[string]string{
+		"title":   "name",
+		"ts":      ",date",
+		"missing": "gone",
+	}
+
+	likes := newMappingRule("likes", "posts")
+	likes.Filter = []string{"title"}
+	likes.Nested = "likes"
+	likes.NestedParent = "ts"
+
+	for _, rule := range []*Rule{posts, likes} {
+		if err := rule.prepare(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &River{c: new(Config)}
+
+	tests := []struct {
+		Rule    *Rule
+		Mapping string
+		Issues  []string
+	}{
+		{posts, `{"properties": {"id": {"type": "long"}, "name": {"type": "text"}, "price": {"type": "scaled_float"}, "ts": {"type": "date"}}}`, nil},
+		{posts, `{"properties": {"id": {"type": "keyword"}, "name": {"type": "long"}, "ts": {"type": "long"}}}`, []string{
+			"field name of rule test:posts (column title) is long in index posts, but the rule writes keyword",
+			"field ts of rule test:posts is long in index posts, but the rule writes date",
+		}},
+		{posts, `{"dynamic": "strict", "properties": {"id": {"type": "long"}, "name": {"type": "keyword"}, "ts": {"type": "date"}, "created_at": {"type": "date"}}}`, []string{
+			"field price of rule test:posts is not in the strict mapping of index posts",
+		}},
+		{posts, `{"properties": {"id": {"type": "date"}, "name": {"type": "date"}}}`, []string{
+			"field name of rule test:posts (column title) is date in index posts, but the rule writes keyword",
+		}},
+		{posts, `{"properties": {"name": {"type": "ip"}}}`, []string{
+			"field name of rule test:posts (column title) is ip in index posts, but the rule writes keyword",
+		}},
+		{likes, `{"properties": {"likes": {"type": "object"}}}`, []string{
+			"field likes of rule test:likes is object in index posts, but the rule writes nested",
+		}},
+		{likes, `{"dynamic": "strict", "properties": {"likes": {"type": "nested", "properties": {"title": {"type": "keyword"}}}}}`, []string{
+			"field id of rule test:likes is not in the strict mapping of index posts",
+		}},
+		{likes, `{"dynamic": "strict", "properties": {"likes": {"type": "nested", "dynamic": true, "properties": {"title": {"type": "keyword"}}}}}`, nil},
+	}
+
+	for _, test := range tests {
+		var mapping map[string]interface{}
+		if err := json.Unmarshal([]byte(test.Mapping), &mapping); err != nil {
+			t.Fatal(err)
+		}
+
+		if issues := r.checkRuleMapping(test.Rule, "posts", mapping); !reflect.DeepEqual(issues, test.Issues) {
+			t.Errorf("Mapping: %s, Expected: is %q, but: was %q", test.Mapping, test.Issues, issues)
+		}
+	}
+
+	expected := []string{"field of rule test:posts maps column missing which does not exist"}
+	if issues := posts.missingFieldColumns(); !reflect.DeepEqual(issues, expected) {
+		t.Errorf("Expected: is %q, but: was %q", expected, issues)
+	}
+
+	for _, policy := range []string{"", "off", "warn", "fail"} {
+		if err := checkMappingPolicy(policy); err != nil {
+			t.Errorf("Policy: %s, Expected: nil, but: was %v", policy, err)
+		}
+	}
+
+	if err := checkMappingPolicy("strict"); err == nil {
+		t.Errorf("Policy: strict, Expected: error, but: was nil")
+	}
+}
@@ -0,0 +1,345 @@
+package river
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/elastic"
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+)
+
+// ErrReindexRunning is the error if the index is already being rebuilt.
+var ErrReindexRunning = errors.New("reindex is already running")
+
+// ReindexStatus is the progress of an index rebuild.
+type ReindexStatus struct {
+	// Alias is the index the rules write to.
+	Alias string `json:"alias"`
+	// Index is the new index.
+	Index string `json:"index"`
+	// Old is the indices the alias pointed to.
+	Old      []string   `json:"old,omitempty"`
+	Rows     uint64     `json:"rows"`
+	Running  bool       `json:"running"`
+	Swapped  bool       `json:"swapped"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Reindex rebuilds the index the rules write to without downtime. A new
+// versioned index is created with the body in the mappings directory and
+// backfilled from MySQL while the row events are written to both indices.
+// The alias is then moved to the new index, the old indices are deleted if
+// deleteOld is set. An index which is no alias yet is replaced by the alias,
+// so it can only be rebuilt with deleteOld.
+func (r *River) Reindex(alias string, deleteOld bool) error {
+	if r.ctx.Err() != nil {
+		return errors.New("river is closed")
+	}
+
+	select {
+	case <-r.getCanal().WaitDumpDone():
+	default:
+		return errors.New("can not reindex before dump is done")
+	}
+
+	rules := r.reindexRules(alias)
+	if len(rules) == 0 {
+		return errors.Annotatef(ErrRuleNotExist, "no rule writes to %s", alias)
+	}
+
+	if len(r.c.MappingsDir) == 0 {
+		return errors.New("can not reindex without a mappings directory")
+	}
+
+	mappings, err := elastic.ReadMappings(r.c.MappingsDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	mapping, ok := mappings[alias]
+	if !ok {
+		return errors.Errorf("mappings directory %s has no mapping of %s", r.c.MappingsDir, alias)
+	}
+
+	body := make(map[string]interface{})
+	if err = json.Unmarshal([]byte(mapping), &body); err != nil {
+		return errors.Annotatef(err, "mapping of %s", alias)
+	}
+
+	r.reindexLock.Lock()
+	defer r.reindexLock.Unlock()
+
+	if st, ok := r.reindexes[alias]; ok && st.Running {
+		return ErrReindexRunning
+	}
+
+	st := &ReindexStatus{
+		Alias:   alias,
+		Index:   elastic.VersionedIndex(alias, time.Now()),
+		Running: true,
+		Started: time.Now(),
+	}
+	r.reindexes[alias] = st
+
+	r.wg.Add(1)
+	go r.reindex(rules, body, deleteOld, st)
+
+	return nil
+}
+
+// Reindexes returns the status of the started rebuilds ordered by alias.
+func (r *River) Reindexes() []*ReindexStatus {
+	r.reindexLock.Lock()
+	defer r.reindexLock.Unlock()
+
+	sts := make([]*ReindexStatus, 0, len(r.reindexes))
+	for _, st := range r.reindexes {
+		s := *st
+		sts = append(sts, &s)
+	}
+
+	sort.Slice(sts, func(i, j int) bool { return sts[i].Alias < sts[j].Alias })
+
+	return sts
+}
+
+// reindexRules returns the rules writing to the index of the river, the
+// nested rules last as their documents must exist first.
+func (r *River) reindexRules(index string) []*Rule {
+	r.rulesLock.RLock()
+	defer r.rulesLock.RUnlock()
+
+	var rules []*Rule
+	for _, rule := range r.rules {
+		if len(rule.Sink) == 0 && rule.index == nil && rule.Index == index {
+			rules = append(rules, rule)
+		}
+	}
+
+	sortNestedLast(rules)
+
+	return rules
+}
+
+func (r *River) reindex(rules []*Rule, body map[string]interface{}, deleteOld bool, st *ReindexStatus) {
+	defer r.wg.Done()
+
+	log.Infof("start reindex %s into %s", st.Alias, st.Index)
+
+	err := r.reindexAlias(rules, body, deleteOld, st)
+
+	r.reindexLock.Lock()
+	delete(r.reindexTargets, st.Alias)
+	now := time.Now()
+	st.Running = false
+	st.Finished = &now
+	if err != nil {
+		st.Error = err.Error()
+	}
+	r.reindexLock.Unlock()
+
+	if err != nil {
+		log.Errorf("reindex %s err %v after %d rows", st.Alias, err, st.Rows)
+		return
+	}
+
+	log.Infof("reindex %s done, %d rows into %s in %v", st.Alias, st.Rows, st.Index, now.Sub(st.Started))
+}
+
+func (r *River) reindexAlias(rules []*Rule, body map[string]interface{}, deleteOld bool, st *ReindexStatus) error {
+	old, err := r.es.AliasIndices(st.Alias)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// A concrete index is removed when the alias is added.
+	concrete := false
+	if old == nil {
+		if concrete, err = r.es.Exists(st.Alias); err != nil {
+			return errors.Trace(err)
+		}
+
+		if concrete {
+			if !deleteOld {
+				return errors.Errorf("%s is no alias, it can only be reindexed deleting it", st.Alias)
+			}
+			old = []string{st.Alias}
+		}
+	}
+
+	r.reindexLock.Lock()
+	st.Old = old
+	r.reindexLock.Unlock()
+
+	// The index is dropped on start if the river stops before the rebuild ends.
+	if err = r.reindexStore.Save(st.Alias, st.Index); err != nil {
+		return errors.Trace(err)
+	}
+
+	if err = r.es.CreateIndex(st.Index, body); err != nil {
+		r.removeReindexTarget(st)
+		return errors.Trace(err)
+	}
+
+	r.reindexLock.Lock()
+	r.reindexTargets[st.Alias] = st.Index
+	r.reindexLock.Unlock()
+
+	if err = r.reindexBackfill(rules, st); err != nil {
+		r.dropReindex(st)
+		return errors.Trace(err)
+	}
+
+	if err = r.swapAlias(st, old, concrete); err != nil {
+		r.dropReindex(st)
+		return errors.Trace(err)
+	}
+
+	if deleteOld && !concrete {
+		for _, index := range old {
+			if err = r.es.DeleteIndex(index); err != nil {
+				return errors.Annotatef(err, "delete old index %s", index)
+			}
+		}
+	}
+
+	return nil
+}
+
+// reindexBackfill backfills the tables of the rules into the new index.
+func (r *River) reindexBackfill(rules []*Rule, st *ReindexStatus) error {
+	for _, rule := range rules {
+		err := r.backfillTable(rule.Schema, rule.Table, st.Index, func(n int) {
+			r.reindexLock.Lock()
+			st.Rows += uint64(n)
+			r.reindexLock.Unlock()
+		})
+		if err != nil {
+			return errors.Annotatef(err, "backfill %s", rule.key())
+		}
+	}
+
+	return nil
+}
+
+// swapAlias moves the alias to the new index once the queued events are
+// written. Row events are held back until the events of the alias are no
+// longer written to the new index too.
+func (r *River) swapAlias(st *ReindexStatus, old []string, concrete bool) error {
+	r.rowsLock.Lock()
+	defer r.rowsLock.Unlock()
+
+	if err := r.flush(); err != nil {
+		return errors.Trace(err)
+	}
+
+	actions := []*elastic.AliasAction{{Action: "add", Index: st.Index, Alias: st.Alias}}
+	for _, index := range old {
+		if concrete {
+			actions = append(actions, &elastic.AliasAction{Action: "remove_index", Index: index})
+		} else {
+			actions = append(actions, &elastic.AliasAction{Action: "remove", Index: index, Alias: st.Alias})
+		}
+	}
+
+	if err := r.es.UpdateAliases(actions); err != nil {
+		return errors.Trace(err)
+	}
+
+	r.reindexLock.Lock()
+	delete(r.reindexTargets, st.Alias)
+	st.Swapped = true
+	r.reindexLock.Unlock()
+
+	r.removeReindexTarget(st)
+
+	return nil
+}
+
+// dropReindex deletes the new index of a failed rebuild once the events
+// written to it are sent, so they can not create it again.
+func (r *River) dropReindex(st *ReindexStatus) {
+	r.reindexLock.Lock()
+	delete(r.reindexTargets, st.Alias)
+	r.reindexLock.Unlock()
+
+	if err := r.flush(); err != nil {
+		log.Errorf("flush events of failed reindex %s err %v", st.Alias, err)
+		return
+	}
+
+	if err := r.es.DeleteIndex(st.Index); err != nil {
+		log.Errorf("delete index %s of failed reindex err %v", st.Index, err)
+		return
+	}
+
+	r.removeReindexTarget(st)
+}
+
+// removeReindexTarget removes the stored index of the rebuild, it is no
+// longer dropped on start.
+func (r *River) removeReindexTarget(st *ReindexStatus) {
+	if err := r.reindexStore.Remove(st.Alias); err != nil {
+		log.Errorf("remove stored index %s of reindex %s err %v", st.Index, st.Alias, err)
+	}
+}
+
+// flush waits until the queued requests are sent.
+func (r *River) flush() error {
+	if !r.running.Get() {
+		return nil
+	}
+
+	done := make(chan struct{})
+	select {
+	case r.syncCh <- flushRequest{done}:
+	case <-r.ctx.Done():
+		return errors.Trace(r.ctx.Err())
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-r.ctx.Done():
+		return errors.Trace(r.ctx.Err())
+	}
+}
+
+// dualWrite adds the copies of the events written to an index being rebuilt.
+func (r *River) dualWrite(reqs []*sink.Event) []*sink.Event {
+	r.reindexLock.Lock()
+	defer r.reindexLock.Unlock()
+
+	if len(r.reindexTargets) == 0 {
+		return reqs
+	}
+
+	for _, req := range reqs {
+		if index, ok := r.reindexTargets[req.Index]; ok && len(req.Sink) == 0 {
+			c := *req
+			c.Index = index
+			reqs = append(reqs, &c)
+		}
+	}
+
+	return reqs
+}
+
+// isReindexTarget checks whether the index is being rebuilt.
+func (r *River) isReindexTarget(index string) bool {
+	r.reindexLock.Lock()
+	defer r.reindexLock.Unlock()
+
+	for _, target := range r.reindexTargets {
+		if target == index {
+			return true
+		}
+	}
+
+	return false
+}
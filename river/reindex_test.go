@@ -0,0 +1,97 @@
+package river
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+)
+
+func TestDualWrite(t *testing.T) {
+	r := &River{reindexTargets: map[string]string{"orders": "orders_20190607100910"}}
+
+	reqs := r.dualWrite([]*sink.Event{
+		{Action: sink.ActionIndex, Index: "orders", ID: "1"},
+		{Action: sink.ActionIndex, Index: "users", ID: "1"},
+		{Action: sink.ActionIndex, Index: "orders", ID: "2", Sink: "kafka"},
+		{Action: sink.ActionDelete, Index: "orders", ID: "3"},
+	})
+
+	if len(reqs) != 6 {
+		t.Fatalf("Expected: 2 copies, but: was %+v", reqs)
+	}
+
+	for i, id := range []string{"1", "3"} {
+		req := reqs[4+i]
+		if req.Index != "orders_20190607100910" || req.ID != id || req == reqs[0] {
+			t.Errorf("Expected: copy of %s in orders_20190607100910, but: was %+v", id, req)
+		}
+	}
+
+	if reqs[0].Index != "orders" {
+		t.Errorf("Expected: the event is not changed, but: was %+v", reqs[0])
+	}
+
+	if !r.isReindexTarget("orders_20190607100910") || r.isReindexTarget("orders") {
+		t.Errorf("Expected: only the new index is rebuilt")
+	}
+}
+
+func TestReindexRules(t *testing.T) {
+	users := newDefaultRule("test", "users")
+	users.Index = "users"
+	tags := newDefaultRule("test", "tags")
+	tags.Index = "users"
+	tags.Nested = "tags"
+	kafka := newDefaultRule("test", "orders")
+	kafka.Index = "users"
+	kafka.Sink = "kafka"
+
+	r := &River{rules: map[string]*Rule{
+		tags.key():  tags,
+		users.key(): users,
+		kafka.key(): kafka,
+	}}
+
+	rules := r.reindexRules("users")
+	if len(rules) != 2 || rules[0] != users || rules[1] != tags {
+		t.Errorf("Expected: users and the nested tags, but: was %+v", rules)
+	}
+}
+
+func TestReindexStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newReindexStore(&Config{DataStorage: fsStorageMore, DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, alias := range []string{"orders", "users"} {
+		if err = s.Save(alias, alias+"_20190607100910"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = s.Remove("orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new store reads the saved indices.
+	s, _ = newReindexStore(&Config{DataStorage: fsStorageMore, DataDir: dir})
+	targets, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]string{"users": "users_20190607100910"}
+	if !reflect.DeepEqual(targets, expect) {
+		t.Errorf("Expected: is %v, but: was %v", expect, targets)
+	}
+}
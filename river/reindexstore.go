@@ -0,0 +1,225 @@
+package river
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/go-redis/redis"
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/go/ioutil2"
+)
+
+const (
+	redisReindexKey string = "go-mysql-elasticsearch-reindex"
+
+	reindexFileName string = "reindex.json"
+)
+
+// reindexStore keeps the new index of the running rebuilds by alias in the
+// same data storage as master info, so the index of a rebuild interrupted by
+// a stop can be dropped on start.
+type reindexStore struct {
+	redisClient *redis.Client
+	redisKey    string
+	mode        string
+
+	sync.Mutex
+
+	filePath string
+}
+
+func newReindexStore(c *Config) (*reindexStore, error) {
+	s := new(reindexStore)
+
+	s.mode = c.DataStorage
+
+	switch c.DataStorage {
+	case redisStorageMore:
+		s.redisClient = redis.NewClient(&redis.Options{
+			Addr:     c.RedisAddr,
+			Password: c.RedisPassword,
+			DB:       int(c.RedisDB),
+		})
+
+		_, pingErr := s.redisClient.Ping().Result()
+		if pingErr != nil {
+			return nil, pingErr
+		}
+
+		s.redisKey = redisReindexKey + c.RedisKeyPostfix
+
+	case fsStorageMore:
+		if len(c.DataDir) > 0 {
+			s.filePath = path.Join(c.DataDir, reindexFileName)
+		}
+
+		if mkdirErr := os.MkdirAll(c.DataDir, 0755); mkdirErr != nil {
+			return nil, errors.Trace(mkdirErr)
+		}
+
+	default:
+		return nil, fmt.Errorf("Invalid data storage value received [%s], accepted: %s, %s", c.DataStorage, redisStorageMore, fsStorageMore)
+	}
+
+	return s, nil
+}
+
+// Save stores the new index of the alias.
+func (s *reindexStore) Save(alias string, index string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	switch s.mode {
+	case redisStorageMore:
+		return errors.Trace(s.redisClient.HSet(s.redisKey, alias, index).Err())
+
+	case fsStorageMore:
+		targets, err := s.load()
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		targets[alias] = index
+		return errors.Trace(s.write(targets))
+	}
+
+	return nil
+}
+
+// Remove deletes the stored index of the alias.
+func (s *reindexStore) Remove(alias string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	switch s.mode {
+	case redisStorageMore:
+		return errors.Trace(s.redisClient.HDel(s.redisKey, alias).Err())
+
+	case fsStorageMore:
+		targets, err := s.load()
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		if _, ok := targets[alias]; !ok {
+			return nil
+		}
+
+		delete(targets, alias)
+		return errors.Trace(s.write(targets))
+	}
+
+	return nil
+}
+
+// List returns the stored indices by alias.
+func (s *reindexStore) List() (map[string]string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch s.mode {
+	case redisStorageMore:
+		targets, err := s.redisClient.HGetAll(s.redisKey).Result()
+		return targets, errors.Trace(err)
+
+	case fsStorageMore:
+		targets, err := s.load()
+		return targets, errors.Trace(err)
+	}
+
+	return nil, nil
+}
+
+func (s *reindexStore) load() (map[string]string, error) {
+	targets := make(map[string]string)
+	if len(s.filePath) == 0 {
+		return targets, nil
+	}
+
+	data, err := ioutil.ReadFile(s.filePath)
+	if os.IsNotExist(err) {
+		return targets, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err = json.Unmarshal(data, &targets); err != nil {
+		return nil, errors.Annotatef(err, "read %s", s.filePath)
+	}
+
+	return targets, nil
+}
+
+func (s *reindexStore) write(targets map[string]string) error {
+	if len(s.filePath) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(targets)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(ioutil2.WriteFileAtomic(s.filePath, data, 0644))
+}
+
+func (s *reindexStore) Close() error {
+	if s.redisClient != nil {
+		return s.redisClient.Close()
+	}
+
+	return nil
+}
+
+// dropInterruptedReindexes deletes the new indices of the rebuilds the river
+// stopped during, as they missed the events written since. An index the alias
+// was already moved to is kept.
+func (r *River) dropInterruptedReindexes() error {
+	targets, err := r.reindexStore.List()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	aliases := make([]string, 0, len(targets))
+	for alias := range targets {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	for _, alias := range aliases {
+		index := targets[alias]
+
+		indices, err := r.es.AliasIndices(alias)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		swapped := false
+		for _, i := range indices {
+			if i == index {
+				swapped = true
+			}
+		}
+
+		if swapped {
+			log.Infof("reindex of %s into %s was interrupted after moving the alias, keep it", alias, index)
+		} else {
+			if err = r.es.DeleteIndex(index); err != nil {
+				return errors.Annotatef(err, "delete index %s of interrupted reindex of %s", index, alias)
+			}
+			log.Warnf("reindex of %s was interrupted, deleted its index %s, start it again", alias, index)
+		}
+
+		if err = r.reindexStore.Remove(alias); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package river
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/juju/errors"
+	"github.com/siddontang/go-log/log"
+)
+
+// Reload reads the sources and rules from the config file again and applies
+// them to the running river, the other settings, sinks included, are kept.
+// Existing tables keep syncing from the current position. If the sources
+// changed, canal is restarted at the current position to pick up new tables,
+// which are backfilled if BackfillNewTables is set.
+func (r *River) Reload() error {
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+
+	if r.ctx.Err() != nil {
+		return errors.New("river is closed")
+	}
+
+	if len(r.c.ConfigFile) == 0 {
+		return errors.New("river is not started with a config file")
+	}
+
+	nc, err := NewConfigWithFile(r.c.ConfigFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	c := *r.c
+	c.Sources = nc.Sources
+	c.Rules = nc.Rules
+
+	old := r.getCanal()
+
+	select {
+	case <-old.WaitDumpDone():
+	default:
+		return errors.New("can not reload before dump is done")
+	}
+
+	cn := old
+	restart := !reflect.DeepEqual(includeTableRegex(r.c.Sources), includeTableRegex(c.Sources))
+	if restart {
+		if cn, err = newCanal(&c); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	rules, err := buildRules(&c, cn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if err = checkRuleSinks(rules, r.sinks); err != nil {
+		return errors.Trace(err)
+	}
+
+	if err = r.checkMappings(rules); err != nil {
+		return errors.Trace(err)
+	}
+
+	r.rulesLock.Lock()
+	added := logRulesDiff(r.rules, rules)
+	r.rules = rules
+	r.rulesLock.Unlock()
+
+	r.c.Sources = c.Sources
+	r.c.Rules = c.Rules
+
+	if restart {
+		cn.SetEventHandler(&eventHandler{r, cn})
+
+		r.canalLock.Lock()
+		r.canal = cn
+		r.canalLock.Unlock()
+
+		// Run starts the new canal once the old one stops.
+		select {
+		case r.canalCh <- cn:
+		default:
+			log.Errorf("previous canal restart is still pending, new sources will be synced on restart")
+		}
+		old.Close()
+	}
+
+	log.Infof("config reloaded, %d rules", len(rules))
+
+	if r.c.BackfillNewTables {
+		addedRules := make([]*Rule, 0, len(added))
+		for _, key := range added {
+			addedRules = append(addedRules, rules[key])
+		}
+		// The nested rules wait for the backfills of their parents.
+		sortNestedLast(addedRules)
+
+		for _, rule := range addedRules {
+			if err = r.startBackfill(rule); err != nil {
+				log.Errorf("backfill new rule %s err %v", rule.key(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// logRulesDiff logs the rule changes and returns the keys of the added rules.
+func logRulesDiff(oldRules, newRules map[string]*Rule) []string {
+	var added, removed, changed []string
+
+	for key, rule := range newRules {
+		oldRule, ok := oldRules[key]
+		if !ok {
+			added = append(added, key)
+		} else if !oldRule.sameConfig(rule) {
+			changed = append(changed, key)
+		}
+	}
+
+	for key := range oldRules {
+		if _, ok := newRules[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+
+	log.Infof("reload rules, added: %v, removed: %v, changed: %v", added, removed, changed)
+
+	return added
+}
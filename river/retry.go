@@ -0,0 +1,62 @@
+package river
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultBulkRetries         = 5
+	defaultBulkRetryBackoff    = 100 * time.Millisecond
+	defaultBulkRetryMaxBackoff = 10 * time.Second
+)
+
+// retryPolicy describes how failed bulk requests are retried.
+type retryPolicy struct {
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+func newRetryPolicy(c *Config) *retryPolicy {
+	p := &retryPolicy{
+		retries:    c.BulkRetries,
+		backoff:    c.BulkRetryBackoff,
+		maxBackoff: c.BulkRetryMaxBackoff,
+	}
+
+	if p.retries == 0 {
+		p.retries = defaultBulkRetries
+	}
+
+	if p.backoff == 0 {
+		p.backoff = defaultBulkRetryBackoff
+	}
+
+	if p.maxBackoff == 0 {
+		p.maxBackoff = defaultBulkRetryMaxBackoff
+	}
+
+	if p.maxBackoff < p.backoff {
+		p.maxBackoff = p.backoff
+	}
+
+	return p
+}
+
+// delay returns the jittered time to wait before the given retry attempt,
+// attempts start from 1. The upper bound doubles on every attempt up to maxBackoff
+// and the result is picked randomly from its upper half.
+func (p *retryPolicy) delay(attempt int) time.Duration {
+	d := p.backoff
+	for i := 1; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
@@ -0,0 +1,39 @@
+package river
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := newRetryPolicy(&Config{BulkRetryBackoff: 100 * time.Millisecond, BulkRetryMaxBackoff: time.Second})
+
+	tests := []struct {
+		Attempt int
+		Max     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{50, time.Second},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			d := p.delay(test.Attempt)
+			if d < test.Max/2 || d > test.Max {
+				t.Fatalf("Attempt: %d, Expected: delay in [%v, %v], but: was %v", test.Attempt, test.Max/2, test.Max, d)
+			}
+		}
+	}
+}
+
+func TestRetryPolicyDefaults(t *testing.T) {
+	p := newRetryPolicy(&Config{})
+
+	if p.retries != defaultBulkRetries || p.backoff != defaultBulkRetryBackoff || p.maxBackoff != defaultBulkRetryMaxBackoff {
+		t.Errorf("Expected: default retry policy, but: was %+v", p)
+	}
+}
@@ -9,16 +9,21 @@ import (
 	"time"
 
 	"github.com/fasttrack-solutions/go-mysql-elasticsearch/elastic"
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
 	"github.com/fasttrack-solutions/go-mysql/canal"
 	"github.com/fasttrack-solutions/go-mysql/mysql"
 	"github.com/juju/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/siddontang/go-log/log"
+	"github.com/siddontang/go/sync2"
 )
 
 // ErrRuleNotExist is the error if rule is not defined.
 var ErrRuleNotExist = errors.New("rule is not exist")
 
 // TimeTracker defines time tracker interface.
+//
+// Deprecated: use the river_bulk_duration_seconds metric.
 type TimeTracker interface {
 	Add(d time.Duration)
 }
@@ -29,9 +34,32 @@ type TimeTracker interface {
 type River struct {
 	c *Config
 
-	canal *canal.Canal
+	canal     *canal.Canal
+	canalLock sync.RWMutex
+	// canalCh passes the canal replacing the running one on reload.
+	canalCh chan *canal.Canal
 
-	rules map[string]*Rule
+	rules     map[string]*Rule
+	rulesLock sync.RWMutex
+
+	reloadLock sync.Mutex
+
+	// running is set once Run starts syncing.
+	running sync2.AtomicBool
+
+	// rowsLock orders the row events with the chunks read by backfills.
+	rowsLock sync.Mutex
+
+	backfills     map[string]*BackfillStatus
+	backfillsLock sync.Mutex
+
+	reindexes map[string]*ReindexStatus
+	// reindexTargets holds the index being rebuilt by alias, the events of
+	// the alias are written to both while it is backfilled.
+	reindexTargets map[string]string
+	reindexLock    sync.Mutex
+	// reindexStore keeps reindexTargets across restarts.
+	reindexStore *reindexStore
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -40,11 +68,34 @@ type River struct {
 
 	es *elastic.Client
 
+	sinks map[string]sink.Sink
+
 	st *stat
 
 	master *masterInfo
 
+	deadLetters *deadLetterQueue
+
 	syncCh chan interface{}
+
+	retry *retryPolicy
+
+	metrics *collector
+
+	// gtid tracks the GTID set when canal is started from a binlog position.
+	gtid gtidTracker
+
+	// lag is the lag last measured for the metrics.
+	lag     *Lag
+	lagLock sync.Mutex
+
+	// lastEventTime is the binlog timestamp of the last row event.
+	lastEventTime sync2.AtomicInt64
+	// flushedEventTime is the binlog timestamp of the last row event flushed to ES.
+	flushedEventTime sync2.AtomicInt64
+	// pendingEventTime is the binlog timestamp of the first row event read
+	// after every read row event was flushed.
+	pendingEventTime sync2.AtomicInt64
 }
 
 // NewRiver creates the River from config
@@ -53,12 +104,18 @@ func NewRiver(c *Config) (*River, error) {
 
 	r.c = c
 
+	if err := checkMappingPolicy(c.MappingCheck); err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	cfg := new(elastic.ClientConfig)
 	cfg.Addr = r.c.ESAddr
 	cfg.User = r.c.ESUser
 	cfg.Password = r.c.ESPassword
 	cfg.HTTPS = r.c.ESHttps
 	cfg.MappingsDir = r.c.MappingsDir
+	cfg.Aliases = r.c.ESAliases
+	cfg.AllowIncompatibleMappings = r.c.AllowIncompatibleMappings
 
 	var err error
 	r.es, err = elastic.NewClient(cfg)
@@ -66,8 +123,12 @@ func NewRiver(c *Config) (*River, error) {
 		return nil, errors.Trace(err)
 	}
 
-	r.rules = make(map[string]*Rule)
 	r.syncCh = make(chan interface{}, 4096)
+	r.canalCh = make(chan *canal.Canal, 1)
+	r.backfills = make(map[string]*BackfillStatus)
+	r.reindexes = make(map[string]*ReindexStatus)
+	r.reindexTargets = make(map[string]string)
+	r.retry = newRetryPolicy(c)
 	r.ctx, r.cancel = context.WithCancel(context.Background())
 
 	if r.master, err = newMasterInfo(c); err != nil {
@@ -78,15 +139,35 @@ func NewRiver(c *Config) (*River, error) {
 		return nil, errors.Trace(err)
 	}
 
-	if err = r.newCanal(); err != nil {
+	if r.deadLetters, err = newDeadLetterQueue(c); err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	if err = r.prepareRule(); err != nil {
+	if r.reindexStore, err = newReindexStore(c); err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	if err = r.prepareCanal(); err != nil {
+	if r.canal, err = newCanal(r.c); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if r.rules, err = buildRules(r.c, r.canal); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if r.sinks, err = newSinks(r.c, r.es); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err = checkRuleSinks(r.rules, r.sinks); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err = r.checkMappings(r.rules); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err = r.prepareCanal(r.canal); err != nil {
 		return nil, errors.Trace(err)
 	}
 
@@ -95,41 +176,68 @@ func NewRiver(c *Config) (*River, error) {
 		return nil, errors.Trace(err)
 	}
 
+	if c.UseGTID {
+		if err = checkGTIDMode(r.canal, c.Flavor); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
 	r.st = &stat{r: r}
 	go r.st.Run(r.c.StatAddr)
 
+	r.metrics = newCollector(r)
+	if err = prometheus.Register(r.metrics); err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	return r, nil
 }
 
-func (r *River) newCanal() error {
+func newCanal(c *Config) (*canal.Canal, error) {
 	cfg := canal.NewDefaultConfig()
-	cfg.Addr = r.c.MyAddr
-	cfg.User = r.c.MyUser
-	cfg.Password = r.c.MyPassword
-	cfg.Charset = r.c.MyCharset
-	cfg.Flavor = r.c.Flavor
-
-	cfg.ServerID = r.c.ServerID
-	cfg.Dump.ExecutionPath = r.c.DumpExec
+	cfg.Addr = c.MyAddr
+	cfg.User = c.MyUser
+	cfg.Password = c.MyPassword
+	cfg.Charset = c.MyCharset
+	cfg.Flavor = c.Flavor
+
+	cfg.ServerID = c.ServerID
+	cfg.Dump.ExecutionPath = c.DumpExec
 	cfg.Dump.DiscardErr = false
-	cfg.Dump.SkipMasterData = r.c.SkipMasterData
+	cfg.Dump.SkipMasterData = c.SkipMasterData
+
+	cfg.IncludeTableRegex = includeTableRegex(c.Sources)
 
-	for _, s := range r.c.Sources {
+	// Decimals are kept exact for the string field modifier.
+	cfg.UseDecimal = true
+
+	cn, err := canal.NewCanal(cfg)
+	return cn, errors.Trace(err)
+}
+
+func includeTableRegex(sources []SourceConfig) []string {
+	var regex []string
+	for _, s := range sources {
 		for _, t := range s.Tables {
-			cfg.IncludeTableRegex = append(cfg.IncludeTableRegex, s.Schema+"\\."+t)
+			regex = append(regex, s.Schema+"\\."+t)
 		}
 	}
 
-	var err error
-	r.canal, err = canal.NewCanal(cfg)
-	return errors.Trace(err)
+	return regex
 }
 
-func (r *River) prepareCanal() error {
+func (r *River) prepareCanal(cn *canal.Canal) error {
+	rules := make([]*Rule, 0, len(r.rules))
+	for _, rule := range r.rules {
+		rules = append(rules, rule)
+	}
+	// The parent documents must be dumped before the nested rows updating them.
+	sortNestedLast(rules)
+
 	var db string
 	dbs := map[string]struct{}{}
-	tables := make([]string, 0, len(r.rules))
-	for _, rule := range r.rules {
+	tables := make([]string, 0, len(rules))
+	for _, rule := range rules {
 		db = rule.Schema
 		dbs[rule.Schema] = struct{}{}
 		tables = append(tables, rule.Table)
@@ -137,7 +245,7 @@ func (r *River) prepareCanal() error {
 
 	if len(dbs) == 1 {
 		// one db, we can shrink using table
-		r.canal.AddDumpTables(db, tables...)
+		cn.AddDumpTables(db, tables...)
 	} else {
 		// many dbs, can only assign databases to dump
 		keys := make([]string, 0, len(dbs))
@@ -145,32 +253,43 @@ func (r *River) prepareCanal() error {
 			keys = append(keys, key)
 		}
 
-		r.canal.AddDumpDatabases(keys...)
+		cn.AddDumpDatabases(keys...)
 	}
 
-	r.canal.SetEventHandler(&eventHandler{r})
+	cn.SetEventHandler(&eventHandler{r, cn})
 
 	return nil
 }
 
-func (r *River) newRule(schema, table string) error {
+func newRule(rules map[string]*Rule, schema, table string) error {
 	key := ruleKey(schema, table)
 
-	if _, ok := r.rules[key]; ok {
+	if _, ok := rules[key]; ok {
 		return errors.Errorf("duplicate source %s, %s defined in config", schema, table)
 	}
 
-	r.rules[key] = newDefaultRule(schema, table)
+	rules[key] = newDefaultRule(schema, table)
 	return nil
 }
 
-func (r *River) updateRule(schema, table string) error {
+func (r *River) getRule(schema, table string) (*Rule, bool) {
+	r.rulesLock.RLock()
+	defer r.rulesLock.RUnlock()
+
+	rule, ok := r.rules[ruleKey(schema, table)]
+	return rule, ok
+}
+
+func (r *River) updateRule(cn *canal.Canal, schema, table string) error {
+	r.rulesLock.Lock()
+	defer r.rulesLock.Unlock()
+
 	rule, ok := r.rules[ruleKey(schema, table)]
 	if !ok {
 		return ErrRuleNotExist
 	}
 
-	tableInfo, err := r.canal.GetTable(schema, table)
+	tableInfo, err := cn.GetTable(schema, table)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -180,11 +299,11 @@ func (r *River) updateRule(schema, table string) error {
 	return nil
 }
 
-func (r *River) parseSource() (map[string][]string, error) {
-	wildTables := make(map[string][]string, len(r.c.Sources))
+func parseSource(c *Config, cn *canal.Canal, rules map[string]*Rule) (map[string][]string, error) {
+	wildTables := make(map[string][]string, len(c.Sources))
 
 	// first, check sources
-	for _, s := range r.c.Sources {
+	for _, s := range c.Sources {
 		if !isValidTables(s.Tables) {
 			return nil, errors.Errorf("wildcard * is not allowed for multiple tables")
 		}
@@ -204,14 +323,14 @@ func (r *River) parseSource() (map[string][]string, error) {
 				sql := fmt.Sprintf(`SELECT table_name FROM information_schema.tables WHERE
 					table_name RLIKE "%s" AND table_schema = "%s";`, buildTable(table), s.Schema)
 
-				res, err := r.canal.Execute(sql)
+				res, err := cn.Execute(sql)
 				if err != nil {
 					return nil, errors.Trace(err)
 				}
 
 				for i := 0; i < res.Resultset.RowNumber(); i++ {
 					f, _ := res.GetString(i, 0)
-					err := r.newRule(s.Schema, f)
+					err := newRule(rules, s.Schema, f)
 					if err != nil {
 						return nil, errors.Trace(err)
 					}
@@ -221,7 +340,7 @@ func (r *River) parseSource() (map[string][]string, error) {
 
 				wildTables[ruleKey(s.Schema, table)] = tables
 			} else {
-				err := r.newRule(s.Schema, table)
+				err := newRule(rules, s.Schema, table)
 				if err != nil {
 					return nil, errors.Trace(err)
 				}
@@ -229,75 +348,160 @@ func (r *River) parseSource() (map[string][]string, error) {
 		}
 	}
 
-	if len(r.rules) == 0 {
+	if len(rules) == 0 {
 		return nil, errors.Errorf("no source data defined")
 	}
 
 	return wildTables, nil
 }
 
-func (r *River) prepareRule() error {
-	wildtables, err := r.parseSource()
+// buildRules makes the rules for the sources and rules in the config.
+func buildRules(c *Config, cn *canal.Canal) (map[string]*Rule, error) {
+	rules := make(map[string]*Rule)
+
+	wildtables, err := parseSource(c, cn, rules)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
 
-	if r.c.Rules != nil {
+	if c.Rules != nil {
 		// then, set custom mapping rule
-		for _, rule := range r.c.Rules {
+		for _, rule := range c.Rules {
 			if len(rule.Schema) == 0 {
-				return errors.Errorf("empty schema not allowed for rule")
+				return nil, errors.Errorf("empty schema not allowed for rule")
 			}
 
 			if regexp.QuoteMeta(rule.Table) != rule.Table {
 				//wildcard table
 				tables, ok := wildtables[ruleKey(rule.Schema, rule.Table)]
 				if !ok {
-					return errors.Errorf("wildcard table for %s.%s is not defined in source", rule.Schema, rule.Table)
+					return nil, errors.Errorf("wildcard table for %s.%s is not defined in source", rule.Schema, rule.Table)
 				}
 
 				if len(rule.Index) == 0 {
-					return errors.Errorf("wildcard table rule %s.%s must have a index, can not empty", rule.Schema, rule.Table)
+					return nil, errors.Errorf("wildcard table rule %s.%s must have a index, can not empty", rule.Schema, rule.Table)
 				}
 
-				rule.prepare()
+				if err = rule.prepare(); err != nil {
+					return nil, errors.Trace(err)
+				}
 
 				for _, table := range tables {
-					rr := r.rules[ruleKey(rule.Schema, table)]
+					rr := rules[ruleKey(rule.Schema, table)]
 					rr.Index = rule.Index
+					rr.index = rule.index
 					rr.ID = rule.ID
 					rr.FieldMapping = rule.FieldMapping
+					rr.Exclude = rule.Exclude
+					rr.Sink = rule.Sink
+					rr.Parent = rule.Parent
+					rr.Routing = rule.Routing
+					rr.Script = rule.Script
+					rr.ScriptParams = rule.ScriptParams
+					rr.Upsert = rule.Upsert
+					rr.ExternalVersion = rule.ExternalVersion
+					rr.VersionColumn = rule.VersionColumn
+					rr.VersionType = rule.VersionType
+					rr.Where = rule.Where
+					rr.where = rule.where
+					rr.SoftDelete = rule.SoftDelete
+					rr.SoftDeleteValue = rule.SoftDeleteValue
+					rr.Nested = rule.Nested
+					rr.NestedParent = rule.NestedParent
+					rr.JoinField = rule.JoinField
+					rr.JoinName = rule.JoinName
 				}
 			} else {
 				key := ruleKey(rule.Schema, rule.Table)
-				if _, ok := r.rules[key]; !ok {
-					return errors.Errorf("rule %s, %s not defined in source", rule.Schema, rule.Table)
+				if _, ok := rules[key]; !ok {
+					return nil, errors.Errorf("rule %s, %s not defined in source", rule.Schema, rule.Table)
+				}
+				if err = rule.prepare(); err != nil {
+					return nil, errors.Trace(err)
 				}
-				rule.prepare()
-				r.rules[key] = rule
+				rules[key] = rule
 			}
 		}
 	}
 
-	rules := make(map[string]*Rule)
-	for key, rule := range r.rules {
-		if rule.TableInfo, err = r.canal.GetTable(rule.Schema, rule.Table); err != nil {
-			return errors.Trace(err)
+	pkRules := make(map[string]*Rule)
+	for key, rule := range rules {
+		if rule.TableInfo, err = cn.GetTable(rule.Schema, rule.Table); err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		for _, column := range rule.routingColumns() {
+			if rule.TableInfo.FindColumn(column) < 0 {
+				return nil, errors.Errorf("routing column %s of rule %s.%s does not exist", column, rule.Schema, rule.Table)
+			}
+		}
+
+		if rule.index != nil {
+			for _, column := range rule.index.columns() {
+				if rule.TableInfo.FindColumn(column) < 0 {
+					return nil, errors.Errorf("index column %s of rule %s.%s does not exist", column, rule.Schema, rule.Table)
+				}
+			}
+		}
+
+		for _, column := range rule.ScriptParams {
+			if rule.TableInfo.FindColumn(column) < 0 {
+				return nil, errors.Errorf("script_params column %s of rule %s.%s does not exist", column, rule.Schema, rule.Table)
+			}
+		}
+
+		if rule.where != nil {
+			for _, column := range rule.where.columns() {
+				if rule.TableInfo.FindColumn(column) < 0 {
+					return nil, errors.Errorf("where column %s of rule %s.%s does not exist", column, rule.Schema, rule.Table)
+				}
+			}
+		}
+
+		for k, v := range rule.FieldMapping {
+			parts := strings.SplitN(v, ",", 2)
+			if len(parts) < 2 {
+				continue
+			}
+
+			if name, arg := splitFieldType(parts[1]); name == fieldTypeGeoPoint && len(arg) > 0 && rule.TableInfo.FindColumn(arg) < 0 {
+				return nil, errors.Errorf("geo_point column %s of rule %s.%s field %s does not exist", arg, rule.Schema, rule.Table, k)
+			}
+
+			// An unsalted hash of a value with few possible values is easily reversed.
+			if name, _ := splitFieldType(parts[1]); name == fieldTypeHash && len(c.HashSalt) == 0 {
+				return nil, errors.Errorf("rule %s.%s field %s is hashed, but hash_salt is not set", rule.Schema, rule.Table, k)
+			}
+		}
+
+		if len(rule.SoftDelete) > 0 && rule.TableInfo.FindColumn(rule.SoftDelete) < 0 {
+			return nil, errors.Errorf("soft_delete column %s of rule %s.%s does not exist", rule.SoftDelete, rule.Schema, rule.Table)
+		}
+
+		if len(rule.VersionColumn) > 0 && rule.TableInfo.FindColumn(rule.VersionColumn) < 0 {
+			return nil, errors.Errorf("version_column %s of rule %s.%s does not exist", rule.VersionColumn, rule.Schema, rule.Table)
+		}
+
+		if len(rule.NestedParent) > 0 && rule.TableInfo.FindColumn(rule.NestedParent) < 0 {
+			return nil, errors.Errorf("nested_parent column %s of rule %s.%s does not exist", rule.NestedParent, rule.Schema, rule.Table)
 		}
 
 		if len(rule.TableInfo.PKColumns) == 0 {
-			if !r.c.SkipNoPkTable {
-				return errors.Errorf("%s.%s must have a PK for a column", rule.Schema, rule.Table)
+			if !c.SkipNoPkTable {
+				return nil, errors.Errorf("%s.%s must have a PK for a column", rule.Schema, rule.Table)
 			}
 
 			log.Errorf("ignored table without a primary key: %s\n", rule.TableInfo.Name)
 		} else {
-			rules[key] = rule
+			pkRules[key] = rule
 		}
 	}
-	r.rules = rules
 
-	return nil
+	if err = linkNestedRules(pkRules); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return pkRules, nil
 }
 
 func ruleKey(schema string, table string) string {
@@ -306,11 +510,58 @@ func ruleKey(schema string, table string) string {
 
 // Run syncs the data from MySQL and inserts to ES.
 func (r *River) Run() error {
+	if err := r.dropInterruptedReindexes(); err != nil {
+		return errors.Trace(err)
+	}
+
+	r.running.Set(true)
+
 	r.wg.Add(1)
 	go r.syncLoop()
 
-	pos := r.master.Position()
-	if err := r.canal.RunFrom(pos); err != nil {
+	r.wg.Add(1)
+	go r.lagLoop()
+
+	if r.c.CheckInterval > 0 {
+		r.wg.Add(1)
+		go r.checkLoop()
+	}
+
+	if r.c.UseGTID {
+		r.wg.Add(1)
+		go r.gtidSeedLoop()
+	}
+
+	cn := r.getCanal()
+
+	var err error
+	if r.c.UseGTID {
+		err = r.runFromGTID(cn)
+	} else {
+		err = cn.RunFrom(r.master.Position())
+	}
+
+	for {
+		select {
+		case next := <-r.canalCh:
+			// The canal was replaced on reload, continue from where it stopped.
+			pos, gset := cn.SyncedPosition(), r.syncedGTIDSet(cn)
+			cn = next
+
+			log.Infof("restart canal at binlog %s, GTID set %v", pos, gset)
+			if gset != nil {
+				err = cn.StartFromGTID(gset)
+			} else {
+				err = cn.RunFrom(pos)
+			}
+			continue
+		default:
+		}
+
+		break
+	}
+
+	if err != nil {
 		log.Errorf("start canal err %v", err)
 		return errors.Trace(err)
 	}
@@ -318,10 +569,68 @@ func (r *River) Run() error {
 	return nil
 }
 
+// runFromGTID starts canal from the saved GTID set. If nothing has been
+// saved yet, canal dumps the data and records the master GTID set first.
+func (r *River) runFromGTID(cn *canal.Canal) error {
+	gset, err := r.master.GTIDSet(r.c.Flavor)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if gset != nil {
+		return cn.StartFromGTID(gset)
+	}
+
+	pos := r.master.Position()
+	if len(pos.Name) > 0 && pos.Pos > 0 {
+		// An empty GTID set would make the master stream all of its binlog again,
+		// keep syncing from the saved position until the GTID set is seeded
+		// from the master once caught up.
+		log.Warnf("no GTID set saved, start from binlog position %s", pos)
+		return cn.RunFrom(pos)
+	}
+
+	if gset, err = mysql.ParseGTIDSet(r.c.Flavor, ""); err != nil {
+		return errors.Trace(err)
+	}
+
+	return cn.StartFromGTID(gset)
+}
+
+// syncedGTIDSet returns the GTID set synced by the canal, nil if GTID is not
+// used or the set is not seeded yet.
+func (r *River) syncedGTIDSet(cn *canal.Canal) mysql.GTIDSet {
+	if !r.c.UseGTID {
+		return cn.SyncedGTIDSet()
+	}
+
+	return r.gtid.synced(cn.SyncedGTIDSet())
+}
+
+func (r *River) getCanal() *canal.Canal {
+	r.canalLock.RLock()
+	defer r.canalLock.RUnlock()
+
+	return r.canal
+}
+
 func (r *River) GetPosition() mysql.Position {
 	return r.master.Position()
 }
 
+// GetGTIDSet returns the last saved GTID set, empty if GTID is not used.
+func (r *River) GetGTIDSet() string {
+	r.master.RLock()
+	defer r.master.RUnlock()
+
+	return r.master.Data.GTIDSet
+}
+
+// WaitDumpDone returns a channel closed when the running canal is done dumping.
+func (r *River) WaitDumpDone() <-chan struct{} {
+	return r.getCanal().WaitDumpDone()
+}
+
 // Ctx returns the internal context for outside use.
 func (r *River) Ctx() context.Context {
 	return r.ctx
@@ -331,13 +640,26 @@ func (r *River) Ctx() context.Context {
 func (r *River) Close() {
 	log.Infof("closing river")
 
+	r.reloadLock.Lock()
+	defer r.reloadLock.Unlock()
+
 	r.cancel()
 
-	r.canal.Close()
+	prometheus.Unregister(r.metrics)
 
-	r.master.Close()
+	r.getCanal().Close()
+
+	// A river which never ran, like the checker, must not overwrite the saved position.
+	if r.running.Get() {
+		r.master.Close()
+	}
 
 	r.wg.Wait()
+
+	r.deadLetters.Close()
+	r.reindexStore.Close()
+
+	closeSinks(r.sinks)
 }
 
 func isValidTables(tables []string) bool {
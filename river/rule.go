@@ -1,9 +1,12 @@
 package river
 
 import (
+	"fmt"
+	"reflect"
 	"strings"
 
 	"github.com/fasttrack-solutions/go-mysql/schema"
+	"github.com/juju/errors"
 )
 
 // Rule is the rule for how to sync data from MySQL to ES.
@@ -11,10 +14,13 @@ import (
 // The mapping rule may thi: schema + table <-> index + document type.
 // schema and table is for MySQL, index and document type is for Elasticsearch.
 type Rule struct {
-	Schema string   `toml:"schema"`
-	Table  string   `toml:"table"`
-	Index  string   `toml:"index"`
-	ID     []string `toml:"id"`
+	Schema string `toml:"schema"`
+	Table  string `toml:"table"`
+	// Index may be a template of the row's columns, e.g.
+	// "events-{created_at:2006.01}" or "orders-{brand_id}".
+	Index string `toml:"index"`
+	index *indexTemplate
+	ID    []string `toml:"id"`
 
 	// Default, a MySQL table field name is mapped to Elasticsearch field name.
 	// Sometimes, you want to use different name, e.g, the MySQL file name is title,
@@ -26,10 +32,68 @@ type Rule struct {
 
 	//only MySQL fields in filter will be synced , default sync all fields
 	Filter []string `toml:"filter"`
+	// MySQL fields in exclude are not synced
+	Exclude []string `toml:"exclude"`
 
 	// Elasticsearch pipeline
 	// To pre-process documents before indexing
 	Pipeline string `toml:"pipeline"`
+
+	// Sink is the name of the sink the documents are written to,
+	// the Elasticsearch of the river if empty.
+	Sink string `toml:"sink"`
+
+	// Parent is the column holding the parent document ID, the documents are
+	// routed by it to the shard of their parent.
+	Parent string `toml:"parent"`
+
+	// Routing is the columns the documents are routed by, their values are
+	// joined like the ID. It can not be used with Parent.
+	Routing []string `toml:"routing"`
+
+	// JoinField is the join field of the index and JoinName the relation of
+	// the documents in it. The parent document ID is set in the join field
+	// if Parent is set.
+	JoinField string `toml:"join_field"`
+	JoinName  string `toml:"join_name"`
+
+	// Script is a painless script run for updates instead of the partial
+	// document update. The values of the ScriptParams columns, or of all
+	// columns if empty, are in params.before and params.after by column name.
+	Script       string   `toml:"script"`
+	ScriptParams []string `toml:"script_params"`
+
+	// Upsert sends the whole row with updates, it is indexed if the
+	// document is missing.
+	Upsert bool `toml:"upsert"`
+
+	// ExternalVersion sends the documents with an external version, the value
+	// of VersionColumn or the binlog position of the row if empty. Documents
+	// are then never overwritten by an older row. VersionType is external or
+	// external_gte.
+	ExternalVersion bool   `toml:"external_version"`
+	VersionColumn   string `toml:"version_column"`
+	VersionType     string `toml:"version_type"`
+
+	// Where is the expression the rows must match to be synced, e.g.
+	// "status != 'draft' AND deleted_at IS NULL".
+	Where string `toml:"where"`
+	where whereExpr
+
+	// SoftDelete is the column marking the row as deleted, the document is
+	// deleted when it is set. The row is deleted if the column holds
+	// SoftDeleteValue, or is not NULL, 0 or a zero date if it is empty.
+	SoftDelete      string `toml:"soft_delete"`
+	SoftDeleteValue string `toml:"soft_delete_value"`
+
+	// Nested is the array field of the documents in Index the rows are kept
+	// in, instead of documents of their own. NestedParent is the column
+	// holding the ID of the document a row belongs to.
+	Nested       string `toml:"nested"`
+	NestedParent string `toml:"nested_parent"`
+	// nestedFields are the fields of the nested rules in the documents the
+	// rule indexes, kept when a document is indexed again.
+	nestedFields []string
 }
 
 func newDefaultRule(schema string, table string) *Rule {
@@ -54,14 +118,200 @@ func (r *Rule) prepare() error {
 		r.Index = r.Table
 	}
 
-	// ES must use a lower-case Index
-	r.Index = strings.ToLower(r.Index)
+	var err error
+	if r.index, err = parseIndexTemplate(r.Index); err != nil {
+		return errors.Annotatef(err, "rule %s.%s", r.Schema, r.Table)
+	}
+
+	// ES must use a lower-case Index, the column names and time layouts of
+	// a template are kept.
+	if r.index == nil {
+		r.Index = strings.ToLower(r.Index)
+	}
+
+	if r.index != nil && len(r.Nested) > 0 {
+		return errors.Errorf("nested rule %s.%s can not have an index template", r.Schema, r.Table)
+	}
+
+	if len(r.Parent) > 0 && len(r.Routing) > 0 {
+		return errors.Errorf("rule %s.%s can not have both parent and routing", r.Schema, r.Table)
+	}
+
+	if len(r.Script) > 0 && (len(r.Pipeline) > 0 || len(r.Nested) > 0) {
+		return errors.Errorf("rule %s.%s can not have a script with a pipeline or nested", r.Schema, r.Table)
+	}
+
+	if r.Upsert && len(r.Nested) > 0 {
+		return errors.Errorf("nested rule %s.%s can not upsert", r.Schema, r.Table)
+	}
+
+	for k, v := range r.FieldMapping {
+		if parts := strings.SplitN(v, ",", 2); len(parts) == 2 {
+			if err := checkFieldType(parts[1]); err != nil {
+				return errors.Annotatef(err, "rule %s.%s field %s", r.Schema, r.Table, k)
+			}
+		}
+	}
+
+	if len(r.Where) > 0 {
+		if r.where, err = parseWhere(r.Where); err != nil {
+			return errors.Annotatef(err, "rule %s.%s", r.Schema, r.Table)
+		}
+	}
+
+	if r.ExternalVersion {
+		if len(r.Script) > 0 || r.Upsert || len(r.Nested) > 0 {
+			return errors.Errorf("rule %s.%s with external_version can not have a script, upsert or nested", r.Schema, r.Table)
+		}
+
+		switch r.VersionType {
+		case "":
+			r.VersionType = versionTypeExternal
+		case versionTypeExternal, versionTypeExternalGTE:
+		default:
+			return errors.Errorf("invalid version_type %s of rule %s.%s", r.VersionType, r.Schema, r.Table)
+		}
+	}
+
+	if (len(r.Nested) > 0) != (len(r.NestedParent) > 0) {
+		return errors.Errorf("rule %s.%s must have both nested and nested_parent", r.Schema, r.Table)
+	}
+
+	if len(r.Nested) > 0 && (len(r.Parent) > 0 || len(r.JoinField) > 0) {
+		return errors.Errorf("nested rule %s.%s can not have a parent or join_field", r.Schema, r.Table)
+	}
+
+	if len(r.JoinField) > 0 && len(r.JoinName) == 0 {
+		return errors.Errorf("rule %s.%s must have a join_name for join_field %s", r.Schema, r.Table, r.JoinField)
+	}
+
+	if len(r.JoinName) > 0 && len(r.JoinField) == 0 {
+		return errors.Errorf("rule %s.%s must have a join_field for join_name %s", r.Schema, r.Table, r.JoinName)
+	}
 
 	return nil
 }
 
+// indexName returns the index of the row's document, the index of the rule if
+// it is no template.
+func (r *Rule) indexName(values []interface{}) string {
+	if r.index == nil || values == nil {
+		return r.Index
+	}
+
+	return r.index.name(r, values)
+}
+
+// indexPattern returns the index pattern matching all the indices of the rule.
+func (r *Rule) indexPattern() string {
+	if r.index == nil {
+		return r.Index
+	}
+
+	return r.index.pattern()
+}
+
+// routingColumns returns the columns the documents are routed by.
+func (r *Rule) routingColumns() []string {
+	if len(r.Parent) > 0 {
+		return []string{r.Parent}
+	}
+
+	return r.Routing
+}
+
+// routing returns the routing of the row's document, empty for the default
+// routing if the rule has no routing columns or they are all NULL.
+func (r *Rule) routing(values []interface{}) string {
+	columns := r.routingColumns()
+	if len(columns) == 0 || values == nil {
+		return ""
+	}
+
+	parts := make([]string, 0, len(columns))
+	null := true
+	for _, column := range columns {
+		v, err := r.TableInfo.GetColumnValue(column, values)
+		if err != nil {
+			return ""
+		}
+
+		if v != nil {
+			null = false
+		}
+		parts = append(parts, columnString(v))
+	}
+
+	if null {
+		return ""
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// indexed checks whether the row has a document, it is not soft deleted and
+// matches the where expression.
+func (r *Rule) indexed(values []interface{}) bool {
+	return !r.softDeleted(values) && r.matches(values)
+}
+
+// matches checks whether the row matches the where expression of the rule.
+func (r *Rule) matches(values []interface{}) bool {
+	return r.where == nil || values == nil || r.where.eval(r.TableInfo, values) == whereTrue
+}
+
+// softDeleted checks whether the row is marked as deleted in the rule's soft
+// delete column.
+func (r *Rule) softDeleted(values []interface{}) bool {
+	if len(r.SoftDelete) == 0 || values == nil {
+		return false
+	}
+
+	v, err := r.TableInfo.GetColumnValue(r.SoftDelete, values)
+	if err != nil || v == nil {
+		return false
+	}
+
+	s := columnString(v)
+	if len(r.SoftDeleteValue) > 0 {
+		return s == r.SoftDeleteValue
+	}
+
+	return s != "0" && s != "" && !strings.HasPrefix(s, "0000-00-00")
+}
+
+// joinValue returns the join field value of the row's document.
+func (r *Rule) joinValue(values []interface{}) interface{} {
+	if len(r.Parent) == 0 {
+		return r.JoinName
+	}
+
+	return map[string]interface{}{
+		"name":   r.JoinName,
+		"parent": r.routing(values),
+	}
+}
+
+// sameConfig checks whether both rules are configured the same, table info is not compared.
+func (r *Rule) sameConfig(o *Rule) bool {
+	a, b := *r, *o
+	a.TableInfo, b.TableInfo = nil, nil
+
+	return reflect.DeepEqual(a, b)
+}
+
+func (r *Rule) key() string {
+	return ruleKey(r.Schema, r.Table)
+}
+
 // CheckFilter checkers whether the field needs to be filtered.
 func (r *Rule) CheckFilter(field string) bool {
+	for _, f := range r.Exclude {
+		if f == field {
+			return false
+		}
+	}
+
 	if r.Filter == nil {
 		return true
 	}
@@ -73,3 +323,15 @@ func (r *Rule) CheckFilter(field string) bool {
 	}
 	return false
 }
+
+// columnString formats the column value like in the document ID, empty for NULL.
+func columnString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	}
+
+	return fmt.Sprintf("%v", v)
+}
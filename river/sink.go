@@ -0,0 +1,99 @@
+package river
+
+import (
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/elastic"
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/juju/errors"
+)
+
+// newSinks creates the sinks in the config, the Elasticsearch of the river is
+// the sink with an empty name.
+func newSinks(c *Config, es *elastic.Client) (map[string]sink.Sink, error) {
+	sinks := map[string]sink.Sink{"": sink.NewElasticsearch(es)}
+
+	for _, sc := range c.Sinks {
+		if len(sc.Name) == 0 {
+			return nil, errors.Errorf("empty name not allowed for sink")
+		}
+
+		if _, ok := sinks[sc.Name]; ok {
+			return nil, errors.Errorf("duplicate sink %s defined in config", sc.Name)
+		}
+
+		s, err := newSink(sc)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, errors.Annotatef(err, "create sink %s", sc.Name)
+		}
+
+		sinks[sc.Name] = s
+	}
+
+	return sinks, nil
+}
+
+func newSink(sc SinkConfig) (sink.Sink, error) {
+	switch sc.Type {
+	case sinkTypeElasticsearch, sinkTypeOpenSearch:
+		cfg := new(elastic.ClientConfig)
+		cfg.Addr = sc.Addr
+		cfg.User = sc.User
+		cfg.Password = sc.Password
+		cfg.HTTPS = sc.HTTPS
+		// OpenSearch 2 rejects mapping types.
+		cfg.OmitType = sc.Type == sinkTypeOpenSearch
+
+		es, err := elastic.NewClient(cfg)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		return sink.NewElasticsearch(es), nil
+
+	case sinkTypeFile:
+		if len(sc.Path) == 0 {
+			return nil, errors.Errorf("file sink must have a path")
+		}
+
+		s, err := sink.NewFile(sc.Path)
+		return s, errors.Trace(err)
+
+	case sinkTypeStdout:
+		return sink.NewStdout(), nil
+
+	case sinkTypeKafka:
+		if len(sc.Brokers) == 0 {
+			return nil, errors.Errorf("kafka sink must have brokers")
+		}
+
+		s, err := sink.NewKafka(sc.Brokers, sc.TopicPrefix)
+		return s, errors.Trace(err)
+	}
+
+	return nil, errors.Errorf("invalid sink type %s, accepted: %s, %s, %s, %s, %s", sc.Type,
+		sinkTypeElasticsearch, sinkTypeOpenSearch, sinkTypeFile, sinkTypeStdout, sinkTypeKafka)
+}
+
+// publishesRows checks whether the sink publishes the row changes instead
+// of the documents.
+func (r *River) publishesRows(rule *Rule) bool {
+	_, ok := r.sinks[rule.Sink].(*sink.Kafka)
+	return ok
+}
+
+// checkRuleSinks checks whether the sinks of the rules are defined.
+func checkRuleSinks(rules map[string]*Rule, sinks map[string]sink.Sink) error {
+	for _, rule := range rules {
+		if _, ok := sinks[rule.Sink]; !ok {
+			return errors.Errorf("sink %s of rule %s.%s not defined in config", rule.Sink, rule.Schema, rule.Table)
+		}
+	}
+
+	return nil
+}
+
+func closeSinks(sinks map[string]sink.Sink) {
+	for _, s := range sinks {
+		s.Close()
+	}
+}
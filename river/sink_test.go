@@ -0,0 +1,66 @@
+package river
+
+import (
+	"testing"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/fasttrack-solutions/go-mysql/canal"
+	"github.com/fasttrack-solutions/go-mysql/mysql"
+	"github.com/fasttrack-solutions/go-mysql/replication"
+	"github.com/fasttrack-solutions/go-mysql/schema"
+)
+
+func TestMakeRowChangeRequest(t *testing.T) {
+	r := &River{st: &stat{}}
+
+	rule := newDefaultRule("test", "t")
+	rule.Sink = "changes"
+	rule.Filter = []string{"id", "title"}
+	rule.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   "t",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "title", Type: schema.TYPE_STRING},
+			{Name: "secret", Type: schema.TYPE_STRING},
+		},
+		PKColumns: []int{0},
+	}
+
+	e := &canal.RowsEvent{
+		Table:  rule.TableInfo,
+		Action: canal.UpdateAction,
+		Rows: [][]interface{}{
+			{int64(1), "a", "x"},
+			{int64(2), "b", "y"},
+		},
+		Header: &replication.EventHeader{Timestamp: 1559893394, LogPos: 1234},
+	}
+
+	reqs, err := r.makeRowChangeRequest(rule, e, mysql.Position{Name: "mysql-bin.000001", Pos: 1234})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 1 {
+		t.Fatalf("Expected: one event, but: was %d", len(reqs))
+	}
+
+	req := reqs[0]
+	if req.Action != sink.ActionUpdate || req.ID != "2" || req.Sink != "changes" || req.Rule != "test:t" {
+		t.Errorf("Expected: update of 2 to changes, but: was %+v", req)
+	}
+
+	change := req.Row
+	if change.Before["title"] != "a" || change.After["title"] != "b" || change.After["id"] != int64(2) {
+		t.Errorf("Expected: row images, but: was %+v", change)
+	}
+
+	if _, ok := change.After["secret"]; ok {
+		t.Errorf("Expected: filtered column is not published, but: was %+v", change.After)
+	}
+
+	if change.BinName != "mysql-bin.000001" || change.BinPos != 1234 || change.Timestamp != 1559893394 {
+		t.Errorf("Expected: binlog position and timestamp, but: was %+v", change)
+	}
+}
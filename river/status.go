@@ -24,7 +24,7 @@ type stat struct {
 func (s *stat) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var buf bytes.Buffer
 
-	rr, err := s.r.canal.Execute("SHOW MASTER STATUS")
+	rr, err := s.r.getCanal().Execute("SHOW MASTER STATUS")
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf("execute sql error %v", err)))
@@ -34,10 +34,18 @@ func (s *stat) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	binName, _ := rr.GetString(0, 0)
 	binPos, _ := rr.GetUint(0, 1)
 
-	pos := s.r.canal.SyncedPosition()
+	pos := s.r.getCanal().SyncedPosition()
 
 	buf.WriteString(fmt.Sprintf("server_current_binlog:(%s, %d)\n", binName, binPos))
 	buf.WriteString(fmt.Sprintf("read_binlog:%s\n", pos))
+	if gset := s.r.syncedGTIDSet(s.r.getCanal()); gset != nil {
+		buf.WriteString(fmt.Sprintf("read_gtid_set:%s\n", gset))
+	}
+
+	if lag, err := s.r.Lag(); err == nil {
+		buf.WriteString(fmt.Sprintf("binlog_lag_bytes:%d\n", lag.Bytes))
+		buf.WriteString(fmt.Sprintf("binlog_lag_seconds:%.0f\n", lag.Seconds))
+	}
 
 	buf.WriteString(fmt.Sprintf("insert_num:%d\n", s.InsertNum.Get()))
 	buf.WriteString(fmt.Sprintf("update_num:%d\n", s.UpdateNum.Get()))
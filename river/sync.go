@@ -6,15 +6,17 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/fasttrack-solutions/go-mysql-elasticsearch/elastic"
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
 	"github.com/fasttrack-solutions/go-mysql/canal"
 	"github.com/fasttrack-solutions/go-mysql/mysql"
 	"github.com/fasttrack-solutions/go-mysql/replication"
 	"github.com/fasttrack-solutions/go-mysql/schema"
 	"github.com/juju/errors"
+	"github.com/shopspring/decimal"
 	"github.com/siddontang/go-log/log"
 )
 
@@ -35,11 +37,18 @@ const mysqlDateFormat = "2006-01-02"
 
 type posSaver struct {
 	pos   mysql.Position
+	gset  mysql.GTIDSet
 	force bool
 }
 
+// flushRequest flushes the queued requests, done is closed once they are sent.
+type flushRequest struct {
+	done chan struct{}
+}
+
 type eventHandler struct {
 	r *River
+	c *canal.Canal
 }
 
 func (h *eventHandler) OnRotate(e *replication.RotateEvent) error {
@@ -48,13 +57,13 @@ func (h *eventHandler) OnRotate(e *replication.RotateEvent) error {
 		Pos:  uint32(e.Position),
 	}
 
-	h.r.syncCh <- posSaver{pos, true}
+	h.r.syncCh <- posSaver{pos, h.r.syncedGTIDSet(h.c), true}
 
 	return h.r.ctx.Err()
 }
 
 func (h *eventHandler) OnTableChanged(schema, table string) error {
-	err := h.r.updateRule(schema, table)
+	err := h.r.updateRule(h.c, schema, table)
 	if err != nil && err != ErrRuleNotExist {
 		return errors.Trace(err)
 	}
@@ -62,29 +71,50 @@ func (h *eventHandler) OnTableChanged(schema, table string) error {
 }
 
 func (h *eventHandler) OnDDL(nextPos mysql.Position, _ *replication.QueryEvent) error {
-	h.r.syncCh <- posSaver{nextPos, true}
+	if h.r.c.UseGTID {
+		h.r.gtid.commit(nextPos)
+	}
+
+	h.r.syncCh <- posSaver{nextPos, h.r.syncedGTIDSet(h.c), true}
 	return h.r.ctx.Err()
 }
 
 func (h *eventHandler) OnXID(nextPos mysql.Position) error {
-	h.r.syncCh <- posSaver{nextPos, false}
+	if h.r.c.UseGTID {
+		h.r.gtid.commit(nextPos)
+	}
+
+	h.r.syncCh <- posSaver{nextPos, h.r.syncedGTIDSet(h.c), false}
 	return h.r.ctx.Err()
 }
 
 func (h *eventHandler) OnRow(e *canal.RowsEvent) error {
-	rule, ok := h.r.rules[ruleKey(e.Table.Schema, e.Table.Name)]
+	h.r.rowsLock.Lock()
+	defer h.r.rowsLock.Unlock()
+
+	rule, ok := h.r.getRule(e.Table.Schema, e.Table.Name)
 	if !ok {
 		return nil
 	}
 
-	var reqs []*elastic.BulkRequest
+	// The position after the event, unknown for dumped rows.
+	pos := mysql.Position{Name: h.c.SyncedPosition().Name}
+	if e.Header != nil {
+		pos.Pos = e.Header.LogPos
+	}
+
+	var reqs []*sink.Event
 	var err error
-	switch e.Action {
-	case canal.InsertAction:
+	switch {
+	case h.r.publishesRows(rule):
+		reqs, err = h.r.makeRowChangeRequest(rule, e, pos)
+	case len(rule.Nested) > 0:
+		reqs, err = h.r.makeNestedRequest(rule, e.Action, e.Rows)
+	case e.Action == canal.InsertAction:
 		reqs, err = h.r.makeInsertRequest(rule, e.Rows)
-	case canal.DeleteAction:
+	case e.Action == canal.DeleteAction:
 		reqs, err = h.r.makeDeleteRequest(rule, e.Rows)
-	case canal.UpdateAction:
+	case e.Action == canal.UpdateAction:
 		reqs, err = h.r.makeUpdateRequest(rule, e.Rows)
 	default:
 		err = errors.Errorf("invalid rows action %s", e.Action)
@@ -95,13 +125,33 @@ func (h *eventHandler) OnRow(e *canal.RowsEvent) error {
 		return errors.Errorf("make %s ES request err %v, close sync", e.Action, err)
 	}
 
-	h.r.syncCh <- reqs
+	setVersions(rule, reqs, pos)
+
+	var ts uint32
+	if e.Header != nil {
+		ts = e.Header.Timestamp
+		// The river is lagging since this event if everything read is flushed.
+		if h.r.lastEventTime.Get() == h.r.flushedEventTime.Get() {
+			h.r.pendingEventTime.Set(int64(ts))
+		}
+		h.r.lastEventTime.Set(int64(ts))
+	}
+
+	h.r.syncCh <- rowsRequest{h.r.dualWrite(reqs), ts}
 
 	return h.r.ctx.Err()
 }
 
+// OnGTID is called before every transaction when GTID is enabled on the master.
+// When syncing from a GTID set canal merges the GTID into its executed set before
+// the transaction's XID or DDL event, so the set is picked up there. When
+// syncing from a binlog position the GTID is added to the tracked set.
 func (h *eventHandler) OnGTID(gtid mysql.GTIDSet) error {
-	return nil
+	log.Debugf("begin transaction with GTID %s", gtid)
+	if h.r.c.UseGTID {
+		h.r.gtid.add(gtid)
+	}
+	return h.r.ctx.Err()
 }
 
 func (h *eventHandler) OnPosSynced(pos mysql.Position, force bool) error {
@@ -130,13 +180,18 @@ func (r *River) syncLoop() {
 	resetTicker := time.NewTicker(3 * time.Second)
 	defer resetTicker.Stop()
 
-	reqs := make([]*elastic.BulkRequest, 0, 1024)
+	reqs := make([]*sink.Event, 0, 1024)
 
 	var pos mysql.Position
+	var gset mysql.GTIDSet
+
+	// timestamp of the last row event in reqs
+	var eventTime uint32
 
 	for {
 		needFlush := false
 		needSavePos := false
+		var flushed chan struct{}
 
 		forceSave := func() {
 			needFlush = true
@@ -148,12 +203,19 @@ func (r *River) syncLoop() {
 			switch v := v.(type) {
 			case posSaver:
 				pos = v.pos
+				gset = v.gset
 				if v.force {
 					forceSave()
 				}
-			case []*elastic.BulkRequest:
-				reqs = append(reqs, v...)
+			case rowsRequest:
+				reqs = append(reqs, v.reqs...)
+				if v.timestamp > 0 {
+					eventTime = v.timestamp
+				}
 				needFlush = len(reqs) >= bulkSize
+			case flushRequest:
+				needFlush = true
+				flushed = v.done
 			}
 		case <-ticker.C:
 			needFlush = true
@@ -164,17 +226,25 @@ func (r *River) syncLoop() {
 		}
 
 		if needFlush {
-			// TODO: retry some times?
 			if err := r.doBulk(reqs); err != nil {
 				log.Errorf("do ES bulk err %v, close sync", err)
 				r.cancel()
 				return
 			}
 			reqs = reqs[0:0]
+
+			if eventTime > 0 {
+				r.flushedEventTime.Set(int64(eventTime))
+				eventTime = 0
+			}
+		}
+
+		if flushed != nil {
+			close(flushed)
 		}
 
 		if needSavePos {
-			if err := r.master.Save(pos); err != nil {
+			if err := r.master.Save(pos, gset); err != nil {
 				log.Errorf("save sync position %s err %v, close sync", pos, err)
 				r.cancel()
 				return
@@ -183,9 +253,31 @@ func (r *River) syncLoop() {
 	}
 }
 
+// newEvent makes the event for the document of the row with the id, values
+// may be nil if the row is not known.
+func newEvent(rule *Rule, id string, values []interface{}) *sink.Event {
+	req := &sink.Event{
+		Index:   rule.indexName(values),
+		ID:      id,
+		Routing: rule.routing(values),
+		Rule:    rule.key(),
+		Sink:    rule.Sink,
+	}
+
+	if rule.ExternalVersion && len(rule.VersionColumn) > 0 {
+		if req.Version = rule.columnVersion(values); req.Version > 0 {
+			req.VersionType = rule.VersionType
+		} else {
+			req.Version = 0
+		}
+	}
+
+	return req
+}
+
 // for insert and delete
-func (r *River) makeRequest(rule *Rule, action string, rows [][]interface{}) ([]*elastic.BulkRequest, error) {
-	reqs := make([]*elastic.BulkRequest, 0, len(rows))
+func (r *River) makeRequest(rule *Rule, action string, rows [][]interface{}) ([]*sink.Event, error) {
+	reqs := make([]*sink.Event, 0, len(rows))
 
 	for _, values := range rows {
 		id, err := r.getDocID(rule, values)
@@ -193,14 +285,23 @@ func (r *River) makeRequest(rule *Rule, action string, rows [][]interface{}) ([]
 			return nil, errors.Trace(err)
 		}
 
-		req := &elastic.BulkRequest{Index: rule.Index, ID: id, Pipeline: rule.Pipeline}
+		// The row has no document.
+		if !rule.indexed(values) {
+			continue
+		}
+
+		req := newEvent(rule, id, values)
+		req.Pipeline = rule.Pipeline
 
 		if action == canal.DeleteAction {
-			req.Action = elastic.ActionDelete
+			req.Action = sink.ActionDelete
 			r.st.DeleteNum.Add(1)
+			rowsCounter.WithLabelValues(rule.key(), canal.DeleteAction).Inc()
 		} else {
 			r.makeInsertReqData(req, rule, values)
+			keepNested(req, rule)
 			r.st.InsertNum.Add(1)
+			rowsCounter.WithLabelValues(rule.key(), canal.InsertAction).Inc()
 		}
 
 		reqs = append(reqs, req)
@@ -209,20 +310,20 @@ func (r *River) makeRequest(rule *Rule, action string, rows [][]interface{}) ([]
 	return reqs, nil
 }
 
-func (r *River) makeInsertRequest(rule *Rule, rows [][]interface{}) ([]*elastic.BulkRequest, error) {
+func (r *River) makeInsertRequest(rule *Rule, rows [][]interface{}) ([]*sink.Event, error) {
 	return r.makeRequest(rule, canal.InsertAction, rows)
 }
 
-func (r *River) makeDeleteRequest(rule *Rule, rows [][]interface{}) ([]*elastic.BulkRequest, error) {
+func (r *River) makeDeleteRequest(rule *Rule, rows [][]interface{}) ([]*sink.Event, error) {
 	return r.makeRequest(rule, canal.DeleteAction, rows)
 }
 
-func (r *River) makeUpdateRequest(rule *Rule, rows [][]interface{}) ([]*elastic.BulkRequest, error) {
+func (r *River) makeUpdateRequest(rule *Rule, rows [][]interface{}) ([]*sink.Event, error) {
 	if len(rows)%2 != 0 {
 		return nil, errors.Errorf("invalid update rows event, must have 2x rows, but %d", len(rows))
 	}
 
-	reqs := make([]*elastic.BulkRequest, 0, len(rows))
+	reqs := make([]*sink.Event, 0, len(rows))
 
 	for i := 0; i < len(rows); i += 2 {
 		beforeID, err := r.getDocID(rule, rows[i])
@@ -236,37 +337,164 @@ func (r *River) makeUpdateRequest(rule *Rule, rows [][]interface{}) ([]*elastic.
 			return nil, errors.Trace(err)
 		}
 
-		req := &elastic.BulkRequest{Index: rule.Index, ID: beforeID}
+		req := newEvent(rule, beforeID, rows[i])
+
+		// A row soft deleted or not matching the where expression has no document.
+		beforeDeleted, afterDeleted := !rule.indexed(rows[i]), !rule.indexed(rows[i+1])
+
+		switch {
+		case beforeDeleted && afterDeleted:
+			continue
+		case afterDeleted:
+			req.Action = sink.ActionDelete
+
+			r.st.DeleteNum.Add(1)
+			rowsCounter.WithLabelValues(rule.key(), canal.DeleteAction).Inc()
+		case beforeDeleted:
+			// The restored or now matching row is indexed.
+			req = newEvent(rule, afterID, rows[i+1])
+			req.Pipeline = rule.Pipeline
+			r.makeInsertReqData(req, rule, rows[i+1])
+			keepNested(req, rule)
 
-		if beforeID != afterID {
-			req.Action = elastic.ActionDelete
+			r.st.InsertNum.Add(1)
+			rowsCounter.WithLabelValues(rule.key(), canal.InsertAction).Inc()
+		case beforeID != afterID || req.Routing != rule.routing(rows[i+1]) || req.Index != rule.indexName(rows[i+1]):
+			// A document routed to another shard or index is moved like one with a new ID.
+			req.Action = sink.ActionDelete
 			reqs = append(reqs, req)
 
-			req = &elastic.BulkRequest{Index: rule.Index, ID: afterID, Pipeline: rule.Pipeline}
+			req = newEvent(rule, afterID, rows[i+1])
+			req.Pipeline = rule.Pipeline
 			r.makeInsertReqData(req, rule, rows[i+1])
+			keepNested(req, rule)
 
 			r.st.DeleteNum.Add(1)
 			r.st.InsertNum.Add(1)
-		} else {
-			if len(rule.Pipeline) > 0 {
-				// Pipelines can only be specified on index action
+			rowsCounter.WithLabelValues(rule.key(), canal.DeleteAction).Inc()
+			rowsCounter.WithLabelValues(rule.key(), canal.InsertAction).Inc()
+		default:
+			if len(rule.Pipeline) > 0 || rule.ExternalVersion {
+				// Pipelines and external versions can only be specified on index action
 				r.makeInsertReqData(req, rule, rows[i+1])
 				// Make sure action is index, not create
-				req.Action = elastic.ActionIndex
+				req.Action = sink.ActionIndex
 				req.Pipeline = rule.Pipeline
+			} else if len(rule.Script) > 0 {
+				r.makeScriptReqData(req, rule, rows[i], rows[i+1])
 			} else {
 				r.makeUpdateReqData(req, rule, rows[i], rows[i+1])
 			}
+			if rule.Upsert && req.Action == sink.ActionUpdate {
+				r.makeUpsertReqData(req, rule, rows[i+1])
+			}
+			r.st.UpdateNum.Add(1)
+			rowsCounter.WithLabelValues(rule.key(), canal.UpdateAction).Inc()
+		}
+
+		reqs = append(reqs, req)
+	}
+
+	return reqs, nil
+}
+
+// makeRowChangeRequest makes an event with the row images for every changed row.
+func (r *River) makeRowChangeRequest(rule *Rule, e *canal.RowsEvent, pos mysql.Position) ([]*sink.Event, error) {
+	step := 1
+	if e.Action == canal.UpdateAction {
+		if len(e.Rows)%2 != 0 {
+			return nil, errors.Errorf("invalid update rows event, must have 2x rows, but %d", len(e.Rows))
+		}
+		step = 2
+	}
+
+	reqs := make([]*sink.Event, 0, len(e.Rows)/step)
+
+	for i := 0; i < len(e.Rows); i += step {
+		change := &sink.RowChange{
+			Schema:  rule.Schema,
+			Table:   rule.Table,
+			Action:  e.Action,
+			BinName: pos.Name,
+			BinPos:  pos.Pos,
+		}
+		if e.Header != nil {
+			change.Timestamp = e.Header.Timestamp
+		}
+
+		values := e.Rows[i]
+
+		// Skip the rows not matching the where expression before and after.
+		if !rule.matches(values) && (e.Action != canal.UpdateAction || !rule.matches(e.Rows[i+1])) {
+			continue
+		}
+
+		var action string
+		switch e.Action {
+		case canal.InsertAction:
+			action = sink.ActionIndex
+			change.After = r.makeRowImage(rule, values)
+			r.st.InsertNum.Add(1)
+		case canal.DeleteAction:
+			action = sink.ActionDelete
+			change.Before = r.makeRowImage(rule, values)
+			r.st.DeleteNum.Add(1)
+		case canal.UpdateAction:
+			action = sink.ActionUpdate
+			values = e.Rows[i+1]
+			change.Before = r.makeRowImage(rule, e.Rows[i])
+			change.After = r.makeRowImage(rule, values)
 			r.st.UpdateNum.Add(1)
+		default:
+			return nil, errors.Errorf("invalid rows action %s", e.Action)
+		}
+
+		id, err := r.getDocID(rule, values)
+		if err != nil {
+			return nil, errors.Trace(err)
 		}
 
+		req := newEvent(rule, id, values)
+		req.Action = action
+		req.Row = change
+		rowsCounter.WithLabelValues(rule.key(), e.Action).Inc()
+
 		reqs = append(reqs, req)
 	}
 
 	return reqs, nil
 }
 
-func (r *River) makeReqColumnData(col *schema.TableColumn, value interface{}) interface{} {
+// makeRowImage returns the values of the row's columns by name.
+func (r *River) makeRowImage(rule *Rule, values []interface{}) map[string]interface{} {
+	image := make(map[string]interface{}, len(values))
+
+	for i, c := range rule.TableInfo.Columns {
+		if !rule.CheckFilter(c.Name) {
+			continue
+		}
+		image[c.Name] = r.makeImageColumnData(rule, &rule.TableInfo.Columns[i], values[i])
+	}
+
+	return image
+}
+
+// makeImageColumnData returns the value of the column in a row image, with
+// the transform of the column's field applied.
+func (r *River) makeImageColumnData(rule *Rule, col *schema.TableColumn, value interface{}) interface{} {
+	data := makeReqColumnData(col, value)
+
+	// The hidden values are not published either.
+	for k, v := range rule.FieldMapping {
+		if mysql, _, fieldType := r.getFieldParts(k, v); mysql == col.Name && isTransform(fieldType) {
+			data = transformValue(fieldType, r.c.HashSalt, data)
+		}
+	}
+
+	return data
+}
+
+func makeReqColumnData(col *schema.TableColumn, value interface{}) interface{} {
 	switch col.Type {
 	case schema.TYPE_ENUM:
 		switch value := value.(type) {
@@ -310,6 +538,12 @@ func (r *River) makeReqColumnData(col *schema.TableColumn, value interface{}) in
 		case []byte:
 			return string(value[:])
 		}
+	case schema.TYPE_DECIMAL:
+		switch value := value.(type) {
+		case decimal.Decimal:
+			f, _ := value.Float64()
+			return f
+		}
 	case schema.TYPE_JSON:
 		var f interface{}
 		var err error
@@ -346,7 +580,8 @@ func (r *River) makeReqColumnData(col *schema.TableColumn, value interface{}) in
 }
 
 func (r *River) getFieldParts(k string, v string) (string, string, string) {
-	composedField := strings.Split(v, ",")
+	// The modifier may hold a comma, e.g. a list separator.
+	composedField := strings.SplitN(v, ",", 2)
 
 	mysql := k
 	elastic := composedField[0]
@@ -362,9 +597,9 @@ func (r *River) getFieldParts(k string, v string) (string, string, string) {
 	return mysql, elastic, fieldType
 }
 
-func (r *River) makeInsertReqData(req *elastic.BulkRequest, rule *Rule, values []interface{}) {
+func (r *River) makeInsertReqData(req *sink.Event, rule *Rule, values []interface{}) {
 	req.Data = make(map[string]interface{}, len(values))
-	req.Action = elastic.ActionIndex
+	req.Action = sink.ActionIndex
 
 	for i, c := range rule.TableInfo.Columns {
 		if !rule.CheckFilter(c.Name) {
@@ -375,45 +610,86 @@ func (r *River) makeInsertReqData(req *elastic.BulkRequest, rule *Rule, values [
 			mysql, elastic, fieldType := r.getFieldParts(k, v)
 			if mysql == c.Name {
 				mapped = true
-				req.Data[elastic] = r.getFieldValue(&c, fieldType, values[i])
+				req.Data[elastic] = r.getRowFieldValue(rule, i, fieldType, values)
 			}
 		}
 		if mapped == false {
-			req.Data[c.Name] = r.makeReqColumnData(&c, values[i])
+			req.Data[c.Name] = makeReqColumnData(&c, values[i])
 		}
 	}
+
+	if len(rule.JoinField) > 0 {
+		req.Data[rule.JoinField] = rule.joinValue(values)
+	}
 }
 
-func (r *River) makeUpdateReqData(req *elastic.BulkRequest, rule *Rule,
+func (r *River) makeUpdateReqData(req *sink.Event, rule *Rule,
 	beforeValues []interface{}, afterValues []interface{}) {
 	req.Data = make(map[string]interface{}, len(beforeValues))
 
 	// maybe dangerous if something wrong delete before?
-	req.Action = elastic.ActionUpdate
+	req.Action = sink.ActionUpdate
 
 	for i, c := range rule.TableInfo.Columns {
 		mapped := false
 		if !rule.CheckFilter(c.Name) {
 			continue
 		}
-		if reflect.DeepEqual(beforeValues[i], afterValues[i]) {
-			//nothing changed
-			continue
-		}
+		changed := !reflect.DeepEqual(beforeValues[i], afterValues[i])
 		for k, v := range rule.FieldMapping {
 			mysql, elastic, fieldType := r.getFieldParts(k, v)
 			if mysql == c.Name {
 				mapped = true
-				req.Data[elastic] = r.getFieldValue(&c, fieldType, afterValues[i])
+				// A field made of several columns changes with any of them.
+				if changed || pairChanged(rule, fieldType, beforeValues, afterValues) {
+					req.Data[elastic] = r.getRowFieldValue(rule, i, fieldType, afterValues)
+				}
 			}
 		}
-		if mapped == false {
-			req.Data[c.Name] = r.makeReqColumnData(&c, afterValues[i])
+		if mapped == false && changed {
+			req.Data[c.Name] = makeReqColumnData(&c, afterValues[i])
 		}
 
 	}
 }
 
+// makeUpsertReqData sets the row as the document indexed if the updated one
+// is missing.
+func (r *River) makeUpsertReqData(req *sink.Event, rule *Rule, values []interface{}) {
+	doc := new(sink.Event)
+	r.makeInsertReqData(doc, rule, values)
+	req.Upsert = doc.Data
+}
+
+// makeScriptReqData makes the update running the rule's script with the row
+// values before and after the update.
+func (r *River) makeScriptReqData(req *sink.Event, rule *Rule, beforeValues []interface{}, afterValues []interface{}) {
+	req.Action = sink.ActionUpdate
+	req.Script = &sink.Script{
+		Source: rule.Script,
+		Params: map[string]interface{}{
+			"before": r.makeScriptParams(rule, beforeValues),
+			"after":  r.makeScriptParams(rule, afterValues),
+		},
+	}
+}
+
+func (r *River) makeScriptParams(rule *Rule, values []interface{}) map[string]interface{} {
+	if len(rule.ScriptParams) == 0 {
+		return r.makeRowImage(rule, values)
+	}
+
+	params := make(map[string]interface{}, len(rule.ScriptParams))
+	for _, column := range rule.ScriptParams {
+		// The column may have been dropped since the rule was checked.
+		if i := rule.TableInfo.FindColumn(column); i >= 0 {
+			params[column] = r.makeImageColumnData(rule, &rule.TableInfo.Columns[i], values[i])
+		}
+	}
+
+	return params
+}
+
 // If id in toml file is none, get primary keys in one row and format them into a string, and PK must not be nil
 // Else get the ID's column in one row and format them into a string
 func (r *River) getDocID(rule *Rule, row []interface{}) (string, error) {
@@ -452,48 +728,165 @@ func (r *River) getDocID(rule *Rule, row []interface{}) (string, error) {
 	return buf.String(), nil
 }
 
-func (r *River) doBulk(reqs []*elastic.BulkRequest) error {
+// doBulk writes the events to their sinks, the events of each sink in order.
+func (r *River) doBulk(reqs []*sink.Event) error {
 	if len(reqs) == 0 {
 		return nil
 	}
 
+	var names []string
+	groups := make(map[string][]*sink.Event)
+	for _, req := range reqs {
+		if _, ok := groups[req.Sink]; !ok {
+			names = append(names, req.Sink)
+		}
+		groups[req.Sink] = append(groups[req.Sink], req)
+	}
+
+	for _, name := range names {
+		s, ok := r.sinks[name]
+		if !ok {
+			return errors.Errorf("sink %s is not defined", name)
+		}
+
+		if err := r.doSinkBulk(s, groups[name]); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	return nil
+}
+
+func (r *River) doSinkBulk(s sink.Sink, reqs []*sink.Event) error {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if attempt > r.retry.retries {
+				return errors.Errorf("%d bulk items still failing after %d retries", len(reqs), r.retry.retries)
+			}
+
+			bulkRetriesCounter.Inc()
+
+			delay := r.retry.delay(attempt)
+			log.Warnf("retry %d bulk items in %v, attempt %d of %d", len(reqs), delay, attempt, r.retry.retries)
+
+			select {
+			case <-time.After(delay):
+			case <-r.ctx.Done():
+				return errors.Trace(r.ctx.Err())
+			}
+		}
+
+		var err error
+		if reqs, err = r.sendBulk(s, reqs); err != nil {
+			return errors.Trace(err)
+		}
+		if len(reqs) == 0 {
+			return nil
+		}
+	}
+}
+
+// sendBulk writes the events once and returns the ones which failed
+// with a retryable error and should be sent again. It fails if the rejected
+// events can not be saved as dead letters.
+func (r *River) sendBulk(s sink.Sink, reqs []*sink.Event) ([]*sink.Event, error) {
 	// Do bulk request.
 	reqStart := time.Now()
 
-	resp, respErr := r.es.Bulk(reqs)
-	if respErr != nil {
-		log.Errorf("sync docs err %v after binlog %s", respErr, r.canal.SyncedPosition())
-		return errors.Trace(respErr)
+	failures, err := s.Write(reqs)
+	if err != nil {
+		log.Errorf("sync docs err %v after binlog %s", err, r.getCanal().SyncedPosition())
+		return reqs, nil
 	}
 
-	// Record ES request processing time.
+	// Record ES request processing time, the time tracker is deprecated in
+	// favour of the bulk duration histogram.
 	r.c.TT.Add(time.Since(reqStart))
+	bulkDurationHistogram.Observe(time.Since(reqStart).Seconds())
+	bulkSizeHistogram.Observe(float64(len(reqs)))
 
-	if resp.Code == http.StatusOK || resp.Errors {
-		for i := 0; i < len(resp.Items); i++ {
-			for action, item := range resp.Items[i] {
-				if len(item.Error) > 0 {
-					log.Errorf("%s index: %s, type: %s, id: %s, status: %d, error: %s",
-						action, item.Index, item.Type, item.ID, item.Status, item.Error)
-				}
-			}
+	var failed []*sink.Event
+	var dls []*DeadLetter
+
+	pos := r.getCanal().SyncedPosition()
+
+	for _, f := range failures {
+		e := f.Event
+
+		// A newer version of the document is already written.
+		if f.Status == http.StatusConflict && e.Version > 0 {
+			log.Debugf("%s index: %s, id: %s, version %d is outdated", e.Action, e.Index, e.ID, e.Version)
+			versionConflictsCounter.Inc()
+			continue
+		}
+
+		// The document is not yet copied to the index being rebuilt, the
+		// backfill reads the changed row later.
+		if f.Status == http.StatusNotFound && r.isReindexTarget(e.Index) {
+			log.Debugf("%s index: %s, id: %s is not yet rebuilt", e.Action, e.Index, e.ID)
+			continue
+		}
+
+		bulkItemErrorsCounter.WithLabelValues(strconv.Itoa(f.Status)).Inc()
+
+		if f.Retryable {
+			log.Warnf("%s index: %s, id: %s, status: %d, will retry, error: %s",
+				e.Action, e.Index, e.ID, f.Status, f.Error)
+			failed = append(failed, e)
+			continue
 		}
+
+		log.Errorf("%s index: %s, id: %s, status: %d, error: %s",
+			e.Action, e.Index, e.ID, f.Status, f.Error)
+
+		dls = append(dls, newDeadLetter(e, pos, f.Status, f.Error))
 	}
 
-	return nil
+	if err := r.deadLetters.Add(dls...); err != nil {
+		return nil, errors.Annotatef(err, "save %d dead letters", len(dls))
+	}
+	deadLettersCounter.Add(float64(len(dls)))
+
+	return failed, nil
+}
+
+// getRowFieldValue converts the value of the row's column i, the modifiers
+// using other columns of the row are applied here.
+func (r *River) getRowFieldValue(rule *Rule, i int, fieldType string, values []interface{}) interface{} {
+	// The date modifier changes the column type.
+	col := rule.TableInfo.Columns[i]
+
+	if name, arg := splitFieldType(fieldType); name == fieldTypeGeoPoint && len(arg) > 0 {
+		return geoPointField(makeReqColumnData(&col, values[i]), columnValue(rule.TableInfo, arg, values))
+	}
+
+	return r.getFieldValue(&col, fieldType, values[i])
+}
+
+// pairChanged checks whether the other column of a field made of two columns changed.
+func pairChanged(rule *Rule, fieldType string, beforeValues []interface{}, afterValues []interface{}) bool {
+	name, arg := splitFieldType(fieldType)
+	if name != fieldTypeGeoPoint || len(arg) == 0 {
+		return false
+	}
+
+	return !reflect.DeepEqual(columnValue(rule.TableInfo, arg, beforeValues), columnValue(rule.TableInfo, arg, afterValues))
 }
 
 // get mysql field value and convert it to specific value to es
 func (r *River) getFieldValue(col *schema.TableColumn, fieldType string, value interface{}) interface{} {
+	name, arg := splitFieldType(fieldType)
+
 	var fieldValue interface{}
-	switch fieldType {
+	switch name {
+	case fieldTypeHash, fieldTypeMask, fieldTypeTruncate:
+		fieldValue = transformValue(fieldType, r.c.HashSalt, makeReqColumnData(col, value))
 	case fieldTypeList:
-		v := r.makeReqColumnData(col, value)
-		if str, ok := v.(string); ok {
-			fieldValue = strings.Split(str, ",")
-		} else {
-			fieldValue = v
+		sep := ","
+		if len(arg) > 0 {
+			sep = arg
 		}
+		fieldValue = listField(makeReqColumnData(col, value), sep)
 
 	case fieldTypeDate:
 		if col.Type == schema.TYPE_NUMBER {
@@ -502,13 +895,31 @@ func (r *River) getFieldValue(col *schema.TableColumn, fieldType string, value i
 			v := reflect.ValueOf(value)
 			switch v.Kind() {
 			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				fieldValue = r.makeReqColumnData(col, time.Unix(v.Int(), 0).Format(mysql.TimeFormat))
+				fieldValue = makeReqColumnData(col, time.Unix(v.Int(), 0).Format(mysql.TimeFormat))
 			}
 		}
+	case fieldTypeJSON:
+		return jsonField(makeReqColumnData(col, value))
+	case fieldTypeGeoPoint:
+		return geoPointField(makeReqColumnData(col, value), nil)
+	case fieldTypeBool:
+		return boolField(makeReqColumnData(col, value))
+	case fieldTypeString:
+		// A DECIMAL is formatted exactly.
+		if col.Type == schema.TYPE_DECIMAL {
+			return stringField(value)
+		}
+		return stringField(makeReqColumnData(col, value))
+	case fieldTypeFloat:
+		return floatField(makeReqColumnData(col, value))
+	case fieldTypeDateMs:
+		return dateMsField(makeReqColumnData(col, value))
+	case fieldTypeDatetime:
+		return datetimeField(value, arg)
 	}
 
 	if fieldValue == nil {
-		fieldValue = r.makeReqColumnData(col, value)
+		fieldValue = makeReqColumnData(col, value)
 	}
 	return fieldValue
 }
@@ -0,0 +1,443 @@
+package river
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/fasttrack-solutions/go-mysql/schema"
+)
+
+func newJoinRule() *Rule {
+	rule := newDefaultRule("test", "answer")
+	rule.Index = "qa"
+	rule.Parent = "question_id"
+	rule.JoinField = "join"
+	rule.JoinName = "answer"
+	rule.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   "answer",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "question_id", Type: schema.TYPE_NUMBER},
+			{Name: "body", Type: schema.TYPE_STRING},
+		},
+		PKColumns: []int{0},
+	}
+
+	return rule
+}
+
+func TestMakeJoinRequest(t *testing.T) {
+	r := &River{st: &stat{}}
+	rule := newJoinRule()
+
+	reqs, err := r.makeInsertRequest(rule, [][]interface{}{{int64(1), int64(10), "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	join := map[string]interface{}{"name": "answer", "parent": "10"}
+	if req := reqs[0]; req.Routing != "10" || !reflect.DeepEqual(req.Data["join"], join) {
+		t.Errorf("Expected: routing 10 and join %v, but: was %+v", join, req)
+	}
+
+	reqs, err = r.makeDeleteRequest(rule, [][]interface{}{{int64(1), int64(10), "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req := reqs[0]; req.Action != sink.ActionDelete || req.Routing != "10" {
+		t.Errorf("Expected: delete routed to 10, but: was %+v", req)
+	}
+
+	rule.Parent = ""
+	rule.JoinName = "question"
+
+	reqs, err = r.makeInsertRequest(rule, [][]interface{}{{int64(1), int64(10), "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req := reqs[0]; req.Routing != "" || req.Data["join"] != "question" {
+		t.Errorf("Expected: parent document, but: was %+v", req)
+	}
+}
+
+func TestMakeJoinUpdateRequest(t *testing.T) {
+	r := &River{st: &stat{}}
+	rule := newJoinRule()
+
+	reqs, err := r.makeUpdateRequest(rule, [][]interface{}{
+		{int64(1), int64(10), "a"},
+		{int64(1), int64(10), "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 1 || reqs[0].Action != sink.ActionUpdate || reqs[0].Routing != "10" {
+		t.Fatalf("Expected: update routed to 10, but: was %+v", reqs)
+	}
+
+	reqs, err = r.makeUpdateRequest(rule, [][]interface{}{
+		{int64(1), int64(10), "a"},
+		{int64(1), int64(11), "a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 2 {
+		t.Fatalf("Expected: delete and index, but: was %+v", reqs)
+	}
+
+	if reqs[0].Action != sink.ActionDelete || reqs[0].Routing != "10" {
+		t.Errorf("Expected: delete routed to 10, but: was %+v", reqs[0])
+	}
+
+	join := map[string]interface{}{"name": "answer", "parent": "11"}
+	if reqs[1].Action != sink.ActionIndex || reqs[1].Routing != "11" || !reflect.DeepEqual(reqs[1].Data["join"], join) {
+		t.Errorf("Expected: index routed to 11, but: was %+v", reqs[1])
+	}
+}
+
+func TestMakeRoutingRequest(t *testing.T) {
+	r := &River{st: &stat{}}
+
+	rule := newDefaultRule("test", "player")
+	rule.Routing = []string{"brand_id"}
+	rule.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   "player",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "brand_id", Type: schema.TYPE_NUMBER},
+			{Name: "region", Type: schema.TYPE_STRING},
+		},
+		PKColumns: []int{0},
+	}
+
+	reqs, err := r.makeUpdateRequest(rule, [][]interface{}{
+		{int64(1), int64(7), "eu"},
+		{int64(2), int64(8), "eu"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 2 {
+		t.Fatalf("Expected: delete and index, but: was %+v", reqs)
+	}
+
+	if reqs[0].Action != sink.ActionDelete || reqs[0].ID != "1" || reqs[0].Routing != "7" {
+		t.Errorf("Expected: delete of 1 routed to 7, but: was %+v", reqs[0])
+	}
+
+	if reqs[1].Action != sink.ActionIndex || reqs[1].ID != "2" || reqs[1].Routing != "8" {
+		t.Errorf("Expected: index of 2 routed to 8, but: was %+v", reqs[1])
+	}
+
+	tests := []struct {
+		Routing []string
+		Values  []interface{}
+		Expect  string
+	}{
+		{[]string{"brand_id"}, []interface{}{int64(1), int64(7), "eu"}, "7"},
+		{[]string{"brand_id", "region"}, []interface{}{int64(1), int64(7), []byte("eu")}, "7:eu"},
+		{[]string{"brand_id", "region"}, []interface{}{int64(1), nil, "eu"}, ":eu"},
+		{[]string{"brand_id"}, []interface{}{int64(1), nil, "eu"}, ""},
+		{nil, []interface{}{int64(1), int64(7), "eu"}, ""},
+	}
+
+	for _, test := range tests {
+		rule.Routing = test.Routing
+		if routing := rule.routing(test.Values); routing != test.Expect {
+			t.Errorf("Routing: %v, Expected: is %q, but: was %q", test.Routing, test.Expect, routing)
+		}
+	}
+}
+
+func TestMakeScriptRequest(t *testing.T) {
+	r := &River{st: &stat{}}
+
+	rule := newDefaultRule("test", "wallet")
+	rule.Script = "ctx._source.balance += params.after.amount - params.before.amount"
+	rule.ScriptParams = []string{"amount"}
+	rule.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   "wallet",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "amount", Type: schema.TYPE_NUMBER},
+			{Name: "note", Type: schema.TYPE_STRING},
+		},
+		PKColumns: []int{0},
+	}
+
+	reqs, err := r.makeUpdateRequest(rule, [][]interface{}{
+		{int64(1), int64(5), "a"},
+		{int64(1), int64(8), "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 1 || reqs[0].Action != sink.ActionUpdate || reqs[0].Script == nil {
+		t.Fatalf("Expected: scripted update, but: was %+v", reqs)
+	}
+
+	expect := &sink.Script{
+		Source: rule.Script,
+		Params: map[string]interface{}{
+			"before": map[string]interface{}{"amount": int64(5)},
+			"after":  map[string]interface{}{"amount": int64(8)},
+		},
+	}
+	if !reflect.DeepEqual(reqs[0].Script, expect) {
+		t.Errorf("Expected: is %+v, but: was %+v", expect, reqs[0].Script)
+	}
+
+	rule.ScriptParams = nil
+	reqs, err = r.makeUpdateRequest(rule, [][]interface{}{
+		{int64(1), int64(5), "a"},
+		{int64(1), int64(8), "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := map[string]interface{}{"id": int64(1), "amount": int64(8), "note": "b"}
+	if !reflect.DeepEqual(reqs[0].Script.Params["after"], after) {
+		t.Errorf("Expected: all columns %v, but: was %v", after, reqs[0].Script.Params["after"])
+	}
+
+	// The hidden values are not passed to the script either.
+	rule.ScriptParams = []string{"amount", "note"}
+	rule.FieldMapping = map[string]string{"note": ",truncate:2"}
+	r.c = new(Config)
+	reqs, err = r.makeUpdateRequest(rule, [][]interface{}{
+		{int64(1), int64(5), "abc"},
+		{int64(1), int64(8), "def"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after = map[string]interface{}{"amount": int64(8), "note": "de"}
+	if !reflect.DeepEqual(reqs[0].Script.Params["after"], after) {
+		t.Errorf("Expected: is %v, but: was %v", after, reqs[0].Script.Params["after"])
+	}
+}
+
+func TestMakeUpsertRequest(t *testing.T) {
+	r := &River{st: &stat{}}
+
+	rule := newDefaultRule("test", "player")
+	rule.Upsert = true
+	rule.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   "player",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "name", Type: schema.TYPE_STRING},
+			{Name: "level", Type: schema.TYPE_NUMBER},
+		},
+		PKColumns: []int{0},
+	}
+
+	reqs, err := r.makeUpdateRequest(rule, [][]interface{}{
+		{int64(1), "a", int64(1)},
+		{int64(1), "a", int64(2)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := reqs[0]
+	if req.Action != sink.ActionUpdate || !reflect.DeepEqual(req.Data, map[string]interface{}{"level": int64(2)}) {
+		t.Errorf("Expected: partial update of level, but: was %+v", req)
+	}
+
+	upsert := map[string]interface{}{"id": int64(1), "name": "a", "level": int64(2)}
+	if !reflect.DeepEqual(req.Upsert, upsert) {
+		t.Errorf("Expected: upsert %v, but: was %v", upsert, req.Upsert)
+	}
+
+	rule.Upsert = false
+	if reqs, err = r.makeUpdateRequest(rule, [][]interface{}{
+		{int64(1), "a", int64(1)},
+		{int64(1), "a", int64(2)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if reqs[0].Upsert != nil {
+		t.Errorf("Expected: no upsert, but: was %v", reqs[0].Upsert)
+	}
+}
+
+func TestMakeSoftDeleteRequest(t *testing.T) {
+	r := &River{st: &stat{}}
+
+	rule := newDefaultRule("test", "player")
+	rule.SoftDelete = "deleted_at"
+	rule.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   "player",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "name", Type: schema.TYPE_STRING},
+			{Name: "deleted_at", Type: schema.TYPE_DATETIME},
+		},
+		PKColumns: []int{0},
+	}
+
+	reqs, err := r.makeInsertRequest(rule, [][]interface{}{
+		{int64(1), "a", nil},
+		{int64(2), "b", "2019-06-07 08:09:10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 1 || reqs[0].ID != "1" {
+		t.Errorf("Expected: soft deleted row is skipped, but: was %+v", reqs)
+	}
+
+	tests := []struct {
+		Before []interface{}
+		After  []interface{}
+		Expect []string
+	}{
+		{[]interface{}{int64(1), "a", nil}, []interface{}{int64(1), "b", nil}, []string{sink.ActionUpdate}},
+		{[]interface{}{int64(1), "a", nil}, []interface{}{int64(1), "a", "2019-06-07 08:09:10"}, []string{sink.ActionDelete}},
+		{[]interface{}{int64(1), "a", "2019-06-07 08:09:10"}, []interface{}{int64(1), "a", nil}, []string{sink.ActionIndex}},
+		{[]interface{}{int64(1), "a", "2019-06-07 08:09:10"}, []interface{}{int64(1), "b", "2019-06-07 08:09:10"}, nil},
+		{[]interface{}{int64(1), "a", "0000-00-00 00:00:00"}, []interface{}{int64(1), "b", "0000-00-00 00:00:00"}, []string{sink.ActionUpdate}},
+	}
+
+	for _, test := range tests {
+		reqs, err := r.makeUpdateRequest(rule, [][]interface{}{test.Before, test.After})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var actions []string
+		for _, req := range reqs {
+			actions = append(actions, req.Action)
+		}
+
+		if !reflect.DeepEqual(actions, test.Expect) {
+			t.Errorf("Before: %v, After: %v, Expected: is %v, but: was %v", test.Before, test.After, test.Expect, actions)
+		}
+	}
+
+	rule.SoftDelete = "name"
+	rule.SoftDeleteValue = "deleted"
+	if rule.softDeleted([]interface{}{int64(1), "a", nil}) || !rule.softDeleted([]interface{}{int64(1), []byte("deleted"), nil}) {
+		t.Errorf("Expected: row is soft deleted with the value %s", rule.SoftDeleteValue)
+	}
+}
+
+func TestMakeWhereRequest(t *testing.T) {
+	r := &River{st: &stat{}}
+
+	rule := newDefaultRule("test", "post")
+	rule.Where = "status != 'draft'"
+	rule.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   "post",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "status", Type: schema.TYPE_STRING},
+		},
+		PKColumns: []int{0},
+	}
+	if err := rule.prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs, err := r.makeInsertRequest(rule, [][]interface{}{{int64(1), "draft"}, {int64(2), "published"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 1 || reqs[0].ID != "2" {
+		t.Errorf("Expected: only the published row, but: was %+v", reqs)
+	}
+
+	reqs, err = r.makeDeleteRequest(rule, [][]interface{}{{int64(1), "draft"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 0 {
+		t.Errorf("Expected: no delete of a draft, but: was %+v", reqs)
+	}
+
+	tests := []struct {
+		Before string
+		After  string
+		Expect []string
+	}{
+		{"draft", "published", []string{sink.ActionIndex}},
+		{"published", "draft", []string{sink.ActionDelete}},
+		{"published", "archived", []string{sink.ActionUpdate}},
+		{"draft", "draft", nil},
+	}
+
+	for _, test := range tests {
+		reqs, err := r.makeUpdateRequest(rule, [][]interface{}{{int64(1), test.Before}, {int64(1), test.After}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var actions []string
+		for _, req := range reqs {
+			actions = append(actions, req.Action)
+		}
+
+		if !reflect.DeepEqual(actions, test.Expect) {
+			t.Errorf("Before: %s, After: %s, Expected: is %v, but: was %v", test.Before, test.After, test.Expect, actions)
+		}
+	}
+}
+
+func TestMakeExcludeRequest(t *testing.T) {
+	r := &River{st: &stat{}, c: &Config{HashSalt: "salt"}}
+
+	rule := newDefaultRule("test", "player")
+	rule.Exclude = []string{"password"}
+	rule.FieldMapping = map[string]string{"email": "email_hash,hash"}
+	rule.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   "player",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "email", Type: schema.TYPE_STRING},
+			{Name: "password", Type: schema.TYPE_STRING},
+		},
+		PKColumns: []int{0},
+	}
+
+	row := []interface{}{int64(1), "john@example.com", "secret"}
+
+	reqs, err := r.makeInsertRequest(rule, [][]interface{}{row})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]interface{}{
+		"id":         int64(1),
+		"email_hash": "84275df39f6d1786a47398ad2d1fd49333063ed7a398902205210d4f068cfd2c",
+	}
+	if !reflect.DeepEqual(reqs[0].Data, expect) {
+		t.Errorf("Expected: is %v, but: was %v", expect, reqs[0].Data)
+	}
+
+	image := r.makeRowImage(rule, row)
+	if _, ok := image["password"]; ok || image["email"] != expect["email_hash"] {
+		t.Errorf("Expected: hidden values in row image, but: was %v", image)
+	}
+}
@@ -0,0 +1,118 @@
+package river
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/fasttrack-solutions/go-mysql/mysql"
+)
+
+// Version types for the rules with external versions.
+const (
+	versionTypeExternal    = "external"
+	versionTypeExternalGTE = "external_gte"
+)
+
+// versionLayouts are the formats of the version columns holding a time.
+var versionLayouts = []string{"2006-01-02 15:04:05.999999", "2006-01-02"}
+
+// setVersions sets the external versions of the rule's events, the version
+// made from the binlog position pos if the rule has no version column.
+// Deletes are sent without a version column value as it does not change.
+func setVersions(rule *Rule, reqs []*sink.Event, pos mysql.Position) {
+	if !rule.ExternalVersion {
+		return
+	}
+
+	for _, req := range reqs {
+		if len(rule.VersionColumn) > 0 {
+			if req.Action == sink.ActionDelete {
+				req.Version, req.VersionType = 0, ""
+			}
+			continue
+		}
+
+		if req.Version = binlogVersion(pos); req.Version > 0 {
+			req.VersionType = rule.VersionType
+		}
+	}
+}
+
+// binlogVersion returns the version of the binlog position, the index of the
+// binlog file in the high 32 bits and the position in the low ones. 0 is
+// returned if the position is not known.
+func binlogVersion(pos mysql.Position) int64 {
+	if pos.Pos == 0 {
+		return 0
+	}
+
+	var index int64
+	if i := strings.LastIndexByte(pos.Name, '.'); i >= 0 {
+		index, _ = strconv.ParseInt(pos.Name[i+1:], 10, 32)
+	}
+
+	return index<<32 | int64(pos.Pos)
+}
+
+// columnVersion returns the version in the rule's version column of the row,
+// times are converted to microseconds since the epoch. 0 is returned if the
+// value is NULL or not a number or time.
+func (r *Rule) columnVersion(values []interface{}) int64 {
+	if values == nil {
+		return 0
+	}
+
+	v, err := r.TableInfo.GetColumnValue(r.VersionColumn, values)
+	if err != nil {
+		return 0
+	}
+
+	switch v := v.(type) {
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case uint:
+		return int64(v)
+	case float32:
+		return int64(v)
+	case float64:
+		return int64(v)
+	case []byte:
+		return parseVersion(string(v))
+	case string:
+		return parseVersion(v)
+	}
+
+	return 0
+}
+
+func parseVersion(s string) int64 {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+
+	for _, layout := range versionLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t.UnixNano() / int64(time.Microsecond)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,114 @@
+package river
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/sink"
+	"github.com/fasttrack-solutions/go-mysql/canal"
+	"github.com/fasttrack-solutions/go-mysql/mysql"
+	"github.com/fasttrack-solutions/go-mysql/schema"
+)
+
+func TestBinlogVersion(t *testing.T) {
+	tests := []struct {
+		Pos    mysql.Position
+		Expect int64
+	}{
+		{mysql.Position{Name: "mysql-bin.000001", Pos: 4}, 1<<32 | 4},
+		{mysql.Position{Name: "mysql-bin.000123", Pos: 1234}, 123<<32 | 1234},
+		{mysql.Position{Name: "mysql-bin.000001", Pos: 0}, 0},
+	}
+
+	for _, test := range tests {
+		if version := binlogVersion(test.Pos); version != test.Expect {
+			t.Errorf("Pos: %v, Expected: is %d, but: was %d", test.Pos, test.Expect, version)
+		}
+	}
+
+	if binlogVersion(mysql.Position{Name: "mysql-bin.000002", Pos: 4}) <= binlogVersion(mysql.Position{Name: "mysql-bin.000001", Pos: 4294967295}) {
+		t.Errorf("Expected: versions grow with the binlog file")
+	}
+}
+
+func TestColumnVersion(t *testing.T) {
+	rule := newDefaultRule("test", "t")
+	rule.VersionColumn = "updated_at"
+	rule.TableInfo = &schema.Table{
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "updated_at", Type: schema.TYPE_DATETIME},
+		},
+	}
+
+	updated := time.Date(2019, 6, 7, 8, 9, 10, 500000000, time.Local)
+
+	tests := []struct {
+		Value  interface{}
+		Expect int64
+	}{
+		{int64(7), 7},
+		{uint32(7), 7},
+		{[]byte("7"), 7},
+		{"2019-06-07 08:09:10.5", updated.UnixNano() / 1000},
+		{"2019-06-07 08:09:10", updated.Truncate(time.Second).UnixNano() / 1000},
+		{"0000-00-00 00:00:00", 0},
+		{nil, 0},
+	}
+
+	for _, test := range tests {
+		if version := rule.columnVersion([]interface{}{int64(1), test.Value}); version != test.Expect {
+			t.Errorf("Value: %v, Expected: is %d, but: was %d", test.Value, test.Expect, version)
+		}
+	}
+}
+
+func TestSetVersions(t *testing.T) {
+	r := &River{st: &stat{}}
+
+	rule := newDefaultRule("test", "t")
+	rule.ExternalVersion = true
+	rule.TableInfo = &schema.Table{
+		Schema: "test",
+		Name:   "t",
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "version", Type: schema.TYPE_NUMBER},
+		},
+		PKColumns: []int{0},
+	}
+	if err := rule.prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs, err := r.makeUpdateRequest(rule, [][]interface{}{
+		{int64(1), int64(3)},
+		{int64(1), int64(4)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setVersions(rule, reqs, mysql.Position{Name: "mysql-bin.000002", Pos: 100})
+
+	// Versioned updates are sent as index requests.
+	if req := reqs[0]; req.Action != sink.ActionIndex || req.Version != 2<<32|100 || req.VersionType != versionTypeExternal {
+		t.Errorf("Expected: index with binlog version, but: was %+v", req)
+	}
+
+	rule.VersionColumn = "version"
+	reqs, err = r.makeRequest(rule, canal.DeleteAction, [][]interface{}{{int64(1), int64(4)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req := reqs[0]; req.Version != 4 {
+		t.Errorf("Expected: version 4 from the column, but: was %+v", req)
+	}
+
+	setVersions(rule, reqs, mysql.Position{Name: "mysql-bin.000002", Pos: 100})
+
+	if req := reqs[0]; req.Version != 0 || req.VersionType != "" {
+		t.Errorf("Expected: delete without a column version, but: was %+v", req)
+	}
+}
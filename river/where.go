@@ -0,0 +1,496 @@
+package river
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/fasttrack-solutions/go-mysql/schema"
+	"github.com/juju/errors"
+)
+
+// whereExpr is a parsed row predicate of a rule, like a SQL WHERE clause with
+// comparisons, IN, IS NULL, AND, OR, NOT and parentheses.
+type whereExpr interface {
+	// eval checks whether the row matches. A comparison with NULL is unknown,
+	// also when negated, and the row only matches if the result is true.
+	eval(t *schema.Table, values []interface{}) whereResult
+	// columns returns the names of the columns used.
+	columns() []string
+}
+
+// whereResult is the result of SQL's three-valued logic.
+type whereResult int
+
+const (
+	whereFalse whereResult = iota
+	whereTrue
+	whereUnknown
+)
+
+func whereBool(b bool) whereResult {
+	if b {
+		return whereTrue
+	}
+
+	return whereFalse
+}
+
+type andExpr struct{ left, right whereExpr }
+
+func (e *andExpr) eval(t *schema.Table, values []interface{}) whereResult {
+	left := e.left.eval(t, values)
+	if left == whereFalse {
+		return whereFalse
+	}
+
+	right := e.right.eval(t, values)
+	if right == whereFalse {
+		return whereFalse
+	} else if left == whereUnknown || right == whereUnknown {
+		return whereUnknown
+	}
+
+	return whereTrue
+}
+
+func (e *andExpr) columns() []string {
+	return append(e.left.columns(), e.right.columns()...)
+}
+
+type orExpr struct{ left, right whereExpr }
+
+func (e *orExpr) eval(t *schema.Table, values []interface{}) whereResult {
+	left := e.left.eval(t, values)
+	if left == whereTrue {
+		return whereTrue
+	}
+
+	right := e.right.eval(t, values)
+	if right == whereTrue {
+		return whereTrue
+	} else if left == whereUnknown || right == whereUnknown {
+		return whereUnknown
+	}
+
+	return whereFalse
+}
+
+func (e *orExpr) columns() []string {
+	return append(e.left.columns(), e.right.columns()...)
+}
+
+type notExpr struct{ expr whereExpr }
+
+func (e *notExpr) eval(t *schema.Table, values []interface{}) whereResult {
+	switch e.expr.eval(t, values) {
+	case whereTrue:
+		return whereFalse
+	case whereFalse:
+		return whereTrue
+	}
+
+	return whereUnknown
+}
+
+func (e *notExpr) columns() []string {
+	return e.expr.columns()
+}
+
+// whereLiteral is a string or number literal.
+type whereLiteral struct {
+	str string
+	num float64
+	// isNum is set for a number literal.
+	isNum bool
+}
+
+// compare compares the column value with the literal, ok is false if they can
+// not be compared. Numbers are compared as numbers, anything else as strings.
+func (l whereLiteral) compare(v interface{}) (c int, ok bool) {
+	if v == nil {
+		return 0, false
+	}
+
+	s := columnString(v)
+	if l.isNum {
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			switch {
+			case n < l.num:
+				return -1, true
+			case n > l.num:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+
+	return strings.Compare(s, l.str), true
+}
+
+type cmpExpr struct {
+	column string
+	op     string
+	value  whereLiteral
+}
+
+func (e *cmpExpr) eval(t *schema.Table, values []interface{}) whereResult {
+	c, ok := e.value.compare(columnValue(t, e.column, values))
+	if !ok {
+		return whereUnknown
+	}
+
+	switch e.op {
+	case "=":
+		return whereBool(c == 0)
+	case "!=", "<>":
+		return whereBool(c != 0)
+	case "<":
+		return whereBool(c < 0)
+	case "<=":
+		return whereBool(c <= 0)
+	case ">":
+		return whereBool(c > 0)
+	case ">=":
+		return whereBool(c >= 0)
+	}
+
+	return whereFalse
+}
+
+func (e *cmpExpr) columns() []string {
+	return []string{e.column}
+}
+
+type inExpr struct {
+	column string
+	values []whereLiteral
+	not    bool
+}
+
+func (e *inExpr) eval(t *schema.Table, values []interface{}) whereResult {
+	v := columnValue(t, e.column, values)
+	if v == nil {
+		return whereUnknown
+	}
+
+	for _, l := range e.values {
+		if c, ok := l.compare(v); ok && c == 0 {
+			return whereBool(!e.not)
+		}
+	}
+
+	return whereBool(e.not)
+}
+
+func (e *inExpr) columns() []string {
+	return []string{e.column}
+}
+
+type nullExpr struct {
+	column string
+	not    bool
+}
+
+func (e *nullExpr) eval(t *schema.Table, values []interface{}) whereResult {
+	return whereBool((columnValue(t, e.column, values) == nil) != e.not)
+}
+
+func (e *nullExpr) columns() []string {
+	return []string{e.column}
+}
+
+// columnValue returns the value of the column in the row. ENUM and SET values
+// are converted like for the documents, as the binlog has their index and the
+// backfills their strings.
+func columnValue(t *schema.Table, column string, values []interface{}) interface{} {
+	v, err := t.GetColumnValue(column, values)
+	if err != nil || v == nil {
+		return nil
+	}
+
+	col := &t.Columns[t.FindColumn(column)]
+	if col.Type == schema.TYPE_ENUM || col.Type == schema.TYPE_SET {
+		return makeReqColumnData(col, v)
+	}
+
+	return v
+}
+
+// parseWhere parses the row predicate.
+func parseWhere(s string) (whereExpr, error) {
+	tokens, err := tokenizeWhere(s)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	p := &whereParser{tokens: tokens}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, errors.Annotatef(err, "parse where %q", s)
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, errors.Errorf("parse where %q: unexpected %s", s, t.text)
+	}
+
+	return expr, nil
+}
+
+const (
+	tokenEOF = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+)
+
+type whereToken struct {
+	kind int
+	text string
+}
+
+func tokenizeWhere(s string) ([]whereToken, error) {
+	var tokens []whereToken
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			// Quotes are escaped by doubling or with a backslash.
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s); j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				} else if s[j] == c {
+					if j+1 < len(s) && s[j+1] == c {
+						j++
+					} else {
+						break
+					}
+				}
+				b.WriteByte(s[j])
+			}
+			if j == len(s) {
+				return nil, errors.Errorf("unterminated %c in where %q", c, s)
+			}
+
+			kind := tokenString
+			if c == '`' {
+				kind = tokenIdent
+			}
+			tokens = append(tokens, whereToken{kind, b.String()})
+			i = j + 1
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(s) && (s[j] == '.' || (s[j] >= '0' && s[j] <= '9')) {
+				j++
+			}
+			tokens = append(tokens, whereToken{tokenNumber, s[i:j]})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, whereToken{tokenIdent, s[i:j]})
+			i = j
+		case strings.HasPrefix(s[i:], "!=") || strings.HasPrefix(s[i:], "<>") ||
+			strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">="):
+			tokens = append(tokens, whereToken{tokenOp, s[i : i+2]})
+			i += 2
+		case strings.IndexByte("=<>(),", c) >= 0:
+			tokens = append(tokens, whereToken{tokenOp, s[i : i+1]})
+			i++
+		default:
+			return nil, errors.Errorf("unexpected %c in where %q", c, s)
+		}
+	}
+
+	return tokens, nil
+}
+
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+func (p *whereParser) peek() whereToken {
+	if p.pos >= len(p.tokens) {
+		return whereToken{kind: tokenEOF, text: "end"}
+	}
+
+	return p.tokens[p.pos]
+}
+
+func (p *whereParser) next() whereToken {
+	t := p.peek()
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+// keyword consumes the next token if it is the keyword.
+func (p *whereParser) keyword(k string) bool {
+	if t := p.peek(); t.kind == tokenIdent && strings.EqualFold(t.text, k) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+// op consumes the next token if it is the operator.
+func (p *whereParser) op(op string) bool {
+	if t := p.peek(); t.kind == tokenOp && t.text == op {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *whereParser) parseOr() (whereExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *whereParser) parseAnd() (whereExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *whereParser) parseNot() (whereExpr, error) {
+	if p.keyword("NOT") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{expr}, nil
+	}
+
+	if p.op("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.op(")") {
+			return nil, errors.Errorf("expected ) but got %s", p.peek().text)
+		}
+		return expr, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *whereParser) parseComparison() (whereExpr, error) {
+	t := p.next()
+	if t.kind != tokenIdent {
+		return nil, errors.Errorf("expected a column but got %s", t.text)
+	}
+	column := t.text
+
+	if p.keyword("IS") {
+		not := p.keyword("NOT")
+		if !p.keyword("NULL") {
+			return nil, errors.Errorf("expected NULL but got %s", p.peek().text)
+		}
+		return &nullExpr{column, not}, nil
+	}
+
+	not := p.keyword("NOT")
+	if p.keyword("IN") {
+		if !p.op("(") {
+			return nil, errors.Errorf("expected ( but got %s", p.peek().text)
+		}
+
+		e := &inExpr{column: column, not: not}
+		for {
+			l, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			e.values = append(e.values, l)
+
+			if p.op(")") {
+				return e, nil
+			}
+			if !p.op(",") {
+				return nil, errors.Errorf("expected , or ) but got %s", p.peek().text)
+			}
+		}
+	}
+	if not {
+		return nil, errors.Errorf("expected IN but got %s", p.peek().text)
+	}
+
+	op := p.next()
+	switch op.text {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+	default:
+		return nil, errors.Errorf("expected a comparison but got %s", op.text)
+	}
+	if op.kind != tokenOp {
+		return nil, errors.Errorf("expected a comparison but got %s", op.text)
+	}
+
+	l, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+
+	return &cmpExpr{column, op.text, l}, nil
+}
+
+func (p *whereParser) parseLiteral() (whereLiteral, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return whereLiteral{str: t.text}, nil
+	case tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return whereLiteral{}, errors.Errorf("invalid number %s", t.text)
+		}
+		return whereLiteral{str: t.text, num: n, isNum: true}, nil
+	case tokenIdent:
+		// Booleans are stored as TINYINT.
+		if strings.EqualFold(t.text, "TRUE") {
+			return whereLiteral{str: "1", num: 1, isNum: true}, nil
+		} else if strings.EqualFold(t.text, "FALSE") {
+			return whereLiteral{str: "0", isNum: true}, nil
+		}
+	}
+
+	return whereLiteral{}, errors.Errorf("expected a string or number but got %s", t.text)
+}
@@ -0,0 +1,119 @@
+package river
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/fasttrack-solutions/go-mysql/schema"
+)
+
+func TestWhere(t *testing.T) {
+	table := &schema.Table{
+		Columns: []schema.TableColumn{
+			{Name: "id", Type: schema.TYPE_NUMBER},
+			{Name: "status", Type: schema.TYPE_STRING},
+			{Name: "amount", Type: schema.TYPE_DECIMAL},
+			{Name: "deleted_at", Type: schema.TYPE_DATETIME},
+		},
+	}
+
+	row := []interface{}{int64(7), "published", "10.50", nil}
+
+	tests := []struct {
+		Where  string
+		Expect bool
+	}{
+		{"status != 'draft'", true},
+		{"status = 'draft'", false},
+		{"status <> \"draft\"", true},
+		{"id = 7", true},
+		{"id >= 8", false},
+		{"amount > 9.5", true},
+		{"amount < 10.5", false},
+		{"id IN (1, 7)", true},
+		{"status NOT IN ('draft', 'published')", false},
+		{"deleted_at IS NULL", true},
+		{"deleted_at IS NOT NULL", false},
+		{"deleted_at = '2019-01-01'", false},
+		{"deleted_at != '2019-01-01'", false},
+		{"status = 'draft' OR id = 7 AND deleted_at IS NULL", true},
+		{"(status = 'draft' OR id = 7) AND deleted_at IS NOT NULL", false},
+		{"NOT status = 'draft'", true},
+		// NULL is unknown, also when negated.
+		{"NOT deleted_at = '2019-01-01'", false},
+		{"NOT deleted_at IN ('2019-01-01')", false},
+		{"NOT (deleted_at = '2019-01-01' AND id = 8)", true},
+		{"NOT (deleted_at = '2019-01-01' OR id = 8)", false},
+		{"deleted_at = '2019-01-01' OR id = 7", true},
+		{"`status` = 'it''s' or id < -1", false},
+		{"status = TRUE", false},
+	}
+
+	for _, test := range tests {
+		expr, err := parseWhere(test.Where)
+		if err != nil {
+			t.Errorf("Where: %s, err: %v", test.Where, err)
+			continue
+		}
+
+		if match := expr.eval(table, row) == whereTrue; match != test.Expect {
+			t.Errorf("Where: %s, Expected: is %v, but: was %v", test.Where, test.Expect, match)
+		}
+	}
+
+	// The binlog has the index of an ENUM value, a backfill its string.
+	table.Columns = append(table.Columns, schema.TableColumn{Name: "kind", Type: schema.TYPE_ENUM, EnumValues: []string{"post", "page"}})
+	for _, kind := range []interface{}{int64(2), "page"} {
+		row := []interface{}{int64(7), "published", "10.50", nil, kind}
+
+		for _, test := range []struct {
+			Where  string
+			Expect bool
+		}{
+			{"kind = 'page'", true},
+			{"kind IN ('post')", false},
+			{"kind != 'post'", true},
+		} {
+			expr, err := parseWhere(test.Where)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if match := expr.eval(table, row) == whereTrue; match != test.Expect {
+				t.Errorf("Where: %s, Kind: %v, Expected: is %v, but: was %v", test.Where, kind, test.Expect, match)
+			}
+		}
+	}
+
+	invalid := []string{
+		"",
+		"status =",
+		"status = 'draft",
+		"status == 'draft'",
+		"status IN 'draft'",
+		"status IS 'draft'",
+		"status = 'draft' AND",
+		"(status = 'draft'",
+		"status = 'draft')",
+		"status NOT = 'draft'",
+		"status ~ 'draft'",
+	}
+
+	for _, where := range invalid {
+		if _, err := parseWhere(where); err == nil {
+			t.Errorf("Where: %s, Expected: an error", where)
+		}
+	}
+
+	expr, err := parseWhere("status = 'a' AND (id IN (1) OR deleted_at IS NULL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns := expr.columns()
+	sort.Strings(columns)
+	if expect := []string{"deleted_at", "id", "status"}; !reflect.DeepEqual(columns, expect) {
+		t.Errorf("Expected: is %v, but: was %v", expect, columns)
+	}
+}
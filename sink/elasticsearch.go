@@ -0,0 +1,82 @@
+package sink
+
+import (
+	"net/http"
+
+	"github.com/fasttrack-solutions/go-mysql-elasticsearch/elastic"
+	"github.com/juju/errors"
+)
+
+// Elasticsearch writes the events with the bulk API, it is used for
+// OpenSearch too.
+type Elasticsearch struct {
+	c *elastic.Client
+}
+
+// NewElasticsearch creates the sink for the client.
+func NewElasticsearch(c *elastic.Client) *Elasticsearch {
+	return &Elasticsearch{c: c}
+}
+
+// Write implements Sink.
+func (s *Elasticsearch) Write(events []*Event) ([]*Failure, error) {
+	reqs := make([]*elastic.BulkRequest, 0, len(events))
+	for _, e := range events {
+		reqs = append(reqs, bulkRequest(e))
+	}
+
+	resp, err := s.c.Bulk(reqs)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// A failed request, e.g. with rotated credentials, is no failure of the
+	// events and must not dead-letter them.
+	if resp.Code != http.StatusOK {
+		return nil, errors.Errorf("bulk status %d: %s", resp.Code, http.StatusText(resp.Code))
+	}
+
+	var failures []*Failure
+
+	for i := 0; i < len(resp.Items) && i < len(events); i++ {
+		for _, item := range resp.Items[i] {
+			if len(item.Error) == 0 {
+				continue
+			}
+
+			failures = append(failures, &Failure{
+				Event:     events[i],
+				Status:    item.Status,
+				Retryable: IsRetryableStatus(item.Status),
+				Error:     item.Error,
+			})
+		}
+	}
+
+	return failures, nil
+}
+
+// Close implements Sink.
+func (s *Elasticsearch) Close() error {
+	return nil
+}
+
+func bulkRequest(e *Event) *elastic.BulkRequest {
+	req := &elastic.BulkRequest{
+		Action:      e.Action,
+		Index:       e.Index,
+		ID:          e.ID,
+		Pipeline:    e.Pipeline,
+		Routing:     e.Routing,
+		Version:     e.Version,
+		VersionType: e.VersionType,
+		Data:        e.Data,
+		Upsert:      e.Upsert,
+	}
+
+	if e.Script != nil {
+		req.Script = &elastic.Script{Source: e.Script.Source, Lang: "painless", Params: e.Script.Params}
+	}
+
+	return req
+}
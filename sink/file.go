@@ -0,0 +1,71 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// File writes the events as JSON lines.
+type File struct {
+	sync.Mutex
+
+	w io.Writer
+	f *os.File
+}
+
+// NewFile creates the sink appending to the file at path.
+func NewFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return &File{w: f, f: f}, nil
+}
+
+// NewStdout creates the sink writing to stdout.
+func NewStdout() *File {
+	return &File{w: os.Stdout}
+}
+
+// Write implements Sink.
+func (s *File) Write(events []*Event) ([]*Failure, error) {
+	var buf bytes.Buffer
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if _, err := s.w.Write(buf.Bytes()); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// The position is saved after the events are written.
+	if s.f != nil {
+		return nil, errors.Trace(s.f.Sync())
+	}
+
+	return nil, nil
+}
+
+// Close implements Sink.
+func (s *File) Close() error {
+	if s.f != nil {
+		return s.f.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := path.Join(dir, "events.jsonl")
+
+	s, err := NewFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []*Event{
+		{Action: ActionIndex, Index: "t", ID: "1", Rule: "test:t", Data: map[string]interface{}{"title": "a"}},
+		{Action: ActionUpdate, Index: "t", ID: "1", Rule: "test:t", Data: map[string]interface{}{"title": "b"}},
+	}
+
+	for _, e := range events {
+		failures, err := s.Write([]*Event{e})
+		if err != nil || len(failures) > 0 {
+			t.Fatalf("Expected: no error, but: was %v, %v", err, failures)
+		}
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var written []*Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		e := new(Event)
+		if err = json.Unmarshal(scanner.Bytes(), e); err != nil {
+			t.Fatal(err)
+		}
+		written = append(written, e)
+	}
+
+	if !reflect.DeepEqual(written, events) {
+		t.Errorf("Expected: is %v, but: was %v", events, written)
+	}
+}
@@ -0,0 +1,112 @@
+package sink
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+)
+
+// Kafka publishes the row changes as JSON messages keyed by the document ID,
+// to a topic per rule named by the topic prefix and the rule's index.
+// Write returns once all messages are acknowledged by the in-sync replicas,
+// so the binlog position is only saved after the changes are published.
+type Kafka struct {
+	p           sarama.SyncProducer
+	topicPrefix string
+}
+
+// NewKafka creates the sink publishing to the brokers.
+func NewKafka(brokers []string, topicPrefix string) (*Kafka, error) {
+	cfg := sarama.NewConfig()
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Return.Successes = true
+	// Keep the changes of a key in order when a request is retried.
+	cfg.Net.MaxOpenRequests = 1
+
+	p, err := sarama.NewSyncProducer(brokers, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return NewKafkaWithProducer(p, topicPrefix), nil
+}
+
+// NewKafkaWithProducer creates the sink publishing with the producer.
+func NewKafkaWithProducer(p sarama.SyncProducer, topicPrefix string) *Kafka {
+	return &Kafka{p: p, topicPrefix: topicPrefix}
+}
+
+// Write implements Sink, the events without a row change are skipped.
+func (s *Kafka) Write(events []*Event) ([]*Failure, error) {
+	msgs := make([]*sarama.ProducerMessage, 0, len(events))
+	for _, e := range events {
+		if e.Row == nil {
+			continue
+		}
+
+		value, err := json.Marshal(e.Row)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		msgs = append(msgs, &sarama.ProducerMessage{
+			Topic:    s.topicPrefix + e.Index,
+			Key:      sarama.StringEncoder(e.ID),
+			Value:    sarama.ByteEncoder(value),
+			Metadata: e,
+		})
+	}
+
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+
+	err := s.p.SendMessages(msgs)
+	if err == nil {
+		return nil, nil
+	}
+
+	perrs, ok := err.(sarama.ProducerErrors)
+	if !ok {
+		return nil, errors.Trace(err)
+	}
+
+	// The messages sent after the first failed one are sent again with it,
+	// so the changes of a key stay in order.
+	reasons := make(map[*sarama.ProducerMessage][]byte, len(perrs))
+	for _, perr := range perrs {
+		reasons[perr.Msg], _ = json.Marshal(perr.Err.Error())
+	}
+
+	first := len(msgs)
+	for i, msg := range msgs {
+		if _, ok := reasons[msg]; ok {
+			first = i
+			break
+		}
+	}
+
+	failures := make([]*Failure, 0, len(msgs)-first)
+	for _, msg := range msgs[first:] {
+		reason, ok := reasons[msg]
+		if !ok {
+			reason, _ = json.Marshal("an earlier message failed")
+		}
+
+		failures = append(failures, &Failure{
+			Event:     msg.Metadata.(*Event),
+			Status:    http.StatusServiceUnavailable,
+			Retryable: true,
+			Error:     reason,
+		})
+	}
+
+	return failures, nil
+}
+
+// Close implements Sink.
+func (s *Kafka) Close() error {
+	return s.p.Close()
+}
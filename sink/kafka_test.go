@@ -0,0 +1,118 @@
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+func TestKafka(t *testing.T) {
+	p := mocks.NewSyncProducer(t, nil)
+	s := NewKafkaWithProducer(p, "river.")
+
+	change := &RowChange{
+		Schema:    "test",
+		Table:     "t",
+		Action:    "update",
+		Before:    map[string]interface{}{"id": float64(1), "title": "a"},
+		After:     map[string]interface{}{"id": float64(1), "title": "b"},
+		BinName:   "mysql-bin.000001",
+		BinPos:    1234,
+		Timestamp: 1559893394,
+	}
+
+	p.ExpectSendMessageWithCheckerFunctionAndSucceed(func(value []byte) error {
+		published := new(RowChange)
+		if err := json.Unmarshal(value, published); err != nil {
+			return err
+		}
+
+		if published.Action != change.Action || published.After["title"] != "b" || published.BinPos != change.BinPos {
+			t.Errorf("Expected: is %+v, but: was %+v", change, published)
+		}
+
+		return nil
+	})
+
+	events := []*Event{
+		// Events without a row change are not published.
+		{Action: ActionDelete, Index: "t", ID: "2"},
+		{Action: ActionUpdate, Index: "t", ID: "1", Row: change},
+	}
+
+	failures, err := s.Write(events)
+	if err != nil || len(failures) > 0 {
+		t.Fatalf("Expected: no error, but: was %v, %v", err, failures)
+	}
+
+	// A failed request is retried by the river.
+	p.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+	if _, err = s.Write(events); err == nil {
+		t.Error("Expected: error, but: was nil")
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKafkaProducerErrors(t *testing.T) {
+	e := &Event{Action: ActionIndex, Index: "t", ID: "1", Row: &RowChange{Schema: "test", Table: "t", Action: "insert"}}
+
+	s := NewKafkaWithProducer(&failingProducer{}, "")
+
+	failures, err := s.Write([]*Event{e})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failures) != 1 || failures[0].Event != e || !failures[0].Retryable {
+		t.Errorf("Expected: one retryable failure, but: was %v", failures)
+	}
+}
+
+func TestKafkaPartialFailure(t *testing.T) {
+	var events []*Event
+	for _, id := range []string{"1", "2", "1", "3"} {
+		events = append(events, &Event{Action: ActionIndex, Index: "t", ID: id, Row: &RowChange{Schema: "test", Table: "t", Action: "update"}})
+	}
+
+	// Only the second message fails, the later ones are sent again too.
+	s := NewKafkaWithProducer(&failingProducer{failed: map[int]bool{1: true}}, "")
+
+	failures, err := s.Write(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failures) != 3 {
+		t.Fatalf("Expected: 3 failures, but: was %d", len(failures))
+	}
+
+	for i, f := range failures {
+		if f.Event != events[i+1] || !f.Retryable {
+			t.Errorf("Failure %d, Expected: is retryable %v, but: was %+v", i, events[i+1], f)
+		}
+	}
+}
+
+// failingProducer fails to deliver the messages at the failed indices, or
+// every message if none are set.
+type failingProducer struct {
+	sarama.SyncProducer
+	failed map[int]bool
+}
+
+func (p *failingProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	var perrs sarama.ProducerErrors
+	for i, msg := range msgs {
+		if len(p.failed) == 0 || p.failed[i] {
+			perrs = append(perrs, &sarama.ProducerError{Msg: msg, Err: errors.New("broker is down")})
+		}
+	}
+
+	return perrs
+}
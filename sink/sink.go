@@ -0,0 +1,93 @@
+// Package sink defines the outputs the river writes the change events to.
+package sink
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Event actions, the same as the Elasticsearch bulk actions.
+const (
+	ActionIndex  = "index"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Event is a change of a document made from a MySQL row by a rule.
+type Event struct {
+	Action   string `json:"action"`
+	Index    string `json:"index"`
+	ID       string `json:"id"`
+	Pipeline string `json:"pipeline,omitempty"`
+	// Routing is the shard routing of the document, the default if empty.
+	Routing string `json:"routing,omitempty"`
+	// Version is the external version of the document if not 0.
+	Version     int64  `json:"version,omitempty"`
+	VersionType string `json:"version_type,omitempty"`
+
+	// Rule identifies the rule the event was made for.
+	Rule string `json:"rule,omitempty"`
+	// Sink is the name of the sink the event is written to.
+	Sink string `json:"sink,omitempty"`
+
+	// Data is the document for index, the changed fields for update.
+	Data map[string]interface{} `json:"data,omitempty"`
+	// Script updates the document instead of Data if set.
+	Script *Script `json:"script,omitempty"`
+	// Upsert is indexed by an update if the document does not exist.
+	Upsert map[string]interface{} `json:"upsert,omitempty"`
+
+	// Row is the row change, only made for the sinks publishing row changes.
+	Row *RowChange `json:"row,omitempty"`
+}
+
+// Script is a painless script run by an update.
+type Script struct {
+	Source string                 `json:"source"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// RowChange is a MySQL row change.
+type RowChange struct {
+	Schema string `json:"schema"`
+	Table  string `json:"table"`
+	// Action is insert, update or delete.
+	Action string `json:"action"`
+
+	// Before is the row before an update or delete, After the row after an
+	// insert or update, by column name.
+	Before map[string]interface{} `json:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty"`
+
+	// BinName and BinPos are the binlog position after the rows event, empty
+	// for backfilled rows.
+	BinName string `json:"bin_name,omitempty"`
+	BinPos  uint32 `json:"bin_pos,omitempty"`
+	// Timestamp is when the rows event was written to the binlog.
+	Timestamp uint32 `json:"timestamp,omitempty"`
+}
+
+// Failure is an event a sink failed to write.
+type Failure struct {
+	Event *Event
+	// Status is the HTTP like status code of the failure.
+	Status int
+	// Retryable is set if the event may be written if sent again.
+	Retryable bool
+	Error     json.RawMessage
+}
+
+// Sink writes the change events to an output.
+type Sink interface {
+	// Write writes the events in order. If an error is returned all events are
+	// written again, otherwise the events which failed are returned.
+	Write(events []*Event) ([]*Failure, error)
+
+	Close() error
+}
+
+// IsRetryableStatus checks whether a request or item with the status code
+// may succeed if sent again.
+func IsRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
@@ -0,0 +1,26 @@
+package sink
+
+import (
+	"testing"
+)
+
+func TestRetryableStatus(t *testing.T) {
+	statuses := []struct {
+		Status int
+		Expect bool
+	}{
+		{200, false},
+		{400, false},
+		{404, false},
+		{409, false},
+		{429, true},
+		{500, true},
+		{503, true},
+	}
+
+	for _, status := range statuses {
+		if IsRetryableStatus(status.Status) != status.Expect {
+			t.Errorf("Status: %d, Expected: is %t, but: was %t", status.Status, status.Expect, IsRetryableStatus(status.Status))
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package verificator
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const metricsNamespace = "verificator"
+
+var (
+	suicideCountGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "suicide_count",
+		Help:      "Number of restarts caused by the verificator.",
+	})
+
+	overThresholdCounterGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "over_threshold_count",
+		Help:      "Number of consecutive checks with the binlog diff over the threshold.",
+	})
+
+	binLogDiffGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "binlog_diff_bytes",
+		Help:      "Binlog diff between the master and the river position found by the last check.",
+	})
+
+	lagSecondsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "lag_seconds",
+		Help:      "Replication lag in seconds found by the last check.",
+	})
+
+	secondsUnsyncedGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "unsynced_seconds",
+		Help:      "Seconds since the binlog diff was zero.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(suicideCountGauge)
+	prometheus.MustRegister(overThresholdCounterGauge)
+	prometheus.MustRegister(binLogDiffGauge)
+	prometheus.MustRegister(lagSecondsGauge)
+	prometheus.MustRegister(secondsUnsyncedGauge)
+}
+
+func (v *Verificator) updateMetrics() {
+	suicideCountGauge.Set(float64(v.suicideCount))
+	overThresholdCounterGauge.Set(float64(v.overThresholdCounter))
+	binLogDiffGauge.Set(float64(v.currentBinLogDiff))
+	lagSecondsGauge.Set(v.currentLagSeconds)
+	secondsUnsyncedGauge.Set(v.secondsUnsynced())
+}
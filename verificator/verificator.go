@@ -8,9 +8,8 @@ import (
 
 	"github.com/ashwanthkumar/slack-go-webhook"
 	"github.com/fasttrack-solutions/go-mysql-elasticsearch/river"
+	"github.com/fasttrack-solutions/go-mysql/mysql"
 	"github.com/go-redis/redis"
-	_ "github.com/go-sql-driver/mysql" // Importing mysql driver
-	"github.com/jmoiron/sqlx"
 	log "github.com/siddontang/go-log/log"
 )
 
@@ -29,11 +28,9 @@ type Config struct {
 	RedisKeyPostfixSuicideCount string
 	RedisKeyPostfixAllowedToRun string
 	RedisDB                     int
-	MyUser                      string
-	MyPass                      string
-	MyAddr                      string
 	SecondsThreshold            int
 	UnSyncedThreshold           int
+	LagSecondsThreshold         int
 	ErrorChan                   chan (error)
 }
 
@@ -42,10 +39,12 @@ type Verificator struct {
 	suicideCount         int
 	overThresholdCounter uint32
 	zeroDate             time.Time
-	threshold            uint32
+	threshold            uint64
 	secondsThreshold     int
-	currentBinLogDiff    uint32
-	lastSyncedRedisPos   uint32
+	lagSecondsThreshold  int
+	currentBinLogDiff    uint64
+	currentLagSeconds    float64
+	lastSyncedPos        mysql.Position
 
 	redisClient                   *redis.Client
 	suicideCountRedisKey          string
@@ -53,10 +52,6 @@ type Verificator struct {
 
 	brandID int
 
-	myUser string
-	myPass string
-	myAddr string
-
 	slackWebhookURL  string
 	slackChannelName string
 
@@ -78,15 +73,13 @@ func InitAndStart(conf Config) (*Verificator, error) {
 	v := Verificator{
 		overThresholdCounter:          0,
 		zeroDate:                      time.Now(),
-		threshold:                     uint32(conf.UnSyncedThreshold),
+		threshold:                     uint64(conf.UnSyncedThreshold),
 		secondsThreshold:              conf.SecondsThreshold,
+		lagSecondsThreshold:           conf.LagSecondsThreshold,
 		redisClient:                   redisClient,
 		suicideCountRedisKey:          "go-mysql-elasticsearch-suicide-count",
 		serviceIsAllowedToRunRedisKey: "go-mysql-elasticsearch-allowed-to-run",
 		brandID:                       conf.BrandID,
-		myUser:                        conf.MyUser,
-		myPass:                        conf.MyPass,
-		myAddr:                        conf.MyAddr,
 		slackWebhookURL:               conf.SlackWebhookURL,
 		slackChannelName:              conf.SlackChannelName,
 		ticker:                        time.NewTicker(time.Duration(conf.VerificatorTickerInterval) * time.Second),
@@ -135,18 +128,10 @@ func (v *Verificator) doTicker(callback func()) {
 	}
 }
 
-type mysqlMasterStatus struct {
-	Name            string `db:"File"`
-	Position        uint32 `db:"Position"`
-	BinlogDoDB      string `db:"Binlog_Do_DB"`
-	BinlogIgnoreDB  string `db:"Binlog_Ignore_DB"`
-	ExecutedGTIDSet string `db:"Executed_Gtid_Set"`
-}
-
 func (v *Verificator) doVerificationCheck(r *river.River) error {
 	var err error
 
-	mysqlMasterStatus, err := v.getMySQLMasterStatus()
+	lag, err := r.Lag()
 	if err != nil {
 		return err
 	}
@@ -156,23 +141,25 @@ func (v *Verificator) doVerificationCheck(r *river.River) error {
 		return err
 	}
 
-	v.currentBinLogDiff = positionsDiff(mysqlMasterStatus.Position, r.GetPosition().Pos)
-	if v.currentBinLogDiff > v.threshold {
+	v.currentBinLogDiff = lag.Bytes
+	v.currentLagSeconds = lag.Seconds
+	if v.overThreshold() {
 		v.overThresholdCounter++
 	} else if v.currentBinLogDiff == 0 {
 		v.zeroDate = time.Now()
 		v.overThresholdCounter = 0
 	}
 
-	if r.GetPosition().Pos == 0 {
+	v.updateMetrics()
+
+	pos := r.GetPosition()
+	if pos.Pos == 0 {
 		log.Info("redis position 0, probably doing mysqldump")
 		return nil
 	}
 
-	bytesSyncedSinceLastRun := r.GetPosition().Pos - v.lastSyncedRedisPos
-
-	if bytesSyncedSinceLastRun > 0 {
-		v.lastSyncedRedisPos = r.GetPosition().Pos
+	if pos.Compare(v.lastSyncedPos) != 0 {
+		v.lastSyncedPos = pos
 		return nil
 	}
 
@@ -194,36 +181,24 @@ func (v *Verificator) doVerificationCheck(r *river.River) error {
 		}
 	}
 
-	v.lastSyncedRedisPos = r.GetPosition().Pos
+	v.lastSyncedPos = pos
 
 	return nil
 }
 
-func (v *Verificator) getMySQLMasterStatus() (mysqlMasterStatus, error) {
-	var status mysqlMasterStatus
-
-	connStr := fmt.Sprintf("%s:%s@tcp(%s)/?parseTime=true", v.myUser, v.myPass, v.myAddr)
-	client, err := sqlx.Connect("mysql", connStr)
-	if err != nil {
-		return status, err
-	}
-	defer client.Close()
-
-	err = client.Get(&status, "SHOW MASTER STATUS;")
-	if err != nil {
-		return status, err
-	}
-
-	return status, nil
-}
-
 func (v *Verificator) commitSuicide() {
 	v.redisClient.Incr(v.suicideCountRedisKey)
 	log.Fatal("terminating service")
 }
 
-func positionsDiff(mysqlPos, redisPos uint32) uint32 {
-	return mysqlPos - redisPos
+// overThreshold checks whether the river lags behind more than allowed, either in
+// binlog bytes or, if configured, in seconds.
+func (v *Verificator) overThreshold() bool {
+	if v.currentBinLogDiff > v.threshold {
+		return true
+	}
+
+	return v.lagSecondsThreshold > 0 && v.currentLagSeconds > float64(v.lagSecondsThreshold)
 }
 
 func (v *Verificator) secondsUnsynced() float64 {
@@ -297,6 +272,11 @@ func (v *Verificator) sendSlackWarning(message string) {
 		Value: fmt.Sprintf("%d bytes", v.currentBinLogDiff),
 	})
 
+	attachment1.AddField(slack.Field{
+		Title: "Current lag",
+		Value: fmt.Sprintf("%.0f seconds", v.currentLagSeconds),
+	})
+
 	attachment1.AddField(slack.Field{
 		Title: "Seconds unsynced",
 		Value: fmt.Sprintf("%f", v.secondsUnsynced()),